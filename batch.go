@@ -0,0 +1,148 @@
+package memorystorecache
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is a key and value for MultiWrite
+type Entry struct {
+	Key   []byte
+	Value []byte
+}
+
+// MissingKeysError is returned by MultiRead when some keys are missing or expired
+// the keys that were found are still returned
+type MissingKeysError struct {
+	Keys [][]byte
+}
+
+func (e *MissingKeysError) Error() string {
+	if len(e.Keys) == 1 {
+		return "1 key not found"
+	}
+	return strconv.Itoa(len(e.Keys)) + " keys not found"
+}
+
+// shardRuns orders keys by shard, calling fn once per shard with the indexes of that shard's keys
+// the sort is stable so a key repeated in one batch is visited in the caller's order and its last value wins
+func (c *Conn) shardRuns(keys []string, fn func(s *shard, idx []int)) {
+	shardOf := make([]int, len(keys))
+	order := make([]int, len(keys))
+	for i, key := range keys {
+		shardOf[i] = keyToShard(key, c.mask)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return shardOf[order[a]] < shardOf[order[b]] })
+
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && shardOf[order[end]] == shardOf[order[start]] {
+			end++
+		}
+		fn(&c.shards[shardOf[order[start]]], order[start:end])
+		start = end
+	}
+}
+
+// MultiRead retrieves many keys, taking each shard's lock once
+// it returns the values of the keys found, and a *MissingKeysError listing the others if any are missing
+func (c *Conn) MultiRead(keys [][]byte) (map[string][]byte, error) {
+	strs := make([]string, len(keys))
+	for i, k := range keys {
+		strs[i] = string(k)
+	}
+
+	found := make(map[string][]byte, len(keys))
+	var hits []snapshotEntry
+	var expired []string
+	var missing [][]byte
+	t := c.now()
+	c.shardRuns(strs, func(s *shard, idx []int) {
+		hits, expired = hits[:0], expired[:0]
+		s.mu.RLock()
+		for _, i := range idx {
+			el, exists := s.dat[strs[i]]
+			if exists && !el.expired(t) {
+				found[strs[i]] = el.dat
+				hits = append(hits, snapshotEntry{key: strs[i], el: el})
+				continue
+			}
+			if exists {
+				expired = append(expired, strs[i])
+			}
+			missing = append(missing, keys[i])
+		}
+		s.mu.RUnlock()
+
+		// as in Read, the policy, expiry queue and refreshes are updated outside the read lock
+		for _, h := range hits {
+			s.access(h.key)
+			c.maybeRefresh(h.key, h.el, t)
+		}
+		for _, key := range expired {
+			s.markExpired(key)
+		}
+		atomic.AddUint64(&c.stats.hits, uint64(len(hits)))
+		atomic.AddUint64(&c.stats.expiredOnRead, uint64(len(expired)))
+	})
+
+	atomic.AddUint64(&c.stats.misses, uint64(len(missing)))
+	if len(missing) > 0 {
+		return found, &MissingKeysError{Keys: missing}
+	}
+	return found, nil
+}
+
+// MultiWrite writes many keys with the same TTL, taking each shard's lock once
+// no key is written if any entry is over the shard memory budget,
+// while entries that would put a namespace over its quota are skipped and ErrNamespaceQuota returned
+func (c *Conn) MultiWrite(entries []Entry, ttl time.Duration) error {
+	o := c.loadObserver()
+	if o == nil {
+		return c.multiWrite(entries, ttl)
+	}
+	start := time.Now()
+	err := c.multiWrite(entries, ttl)
+	o.ObserveWrite(time.Since(start))
+	return err
+}
+
+func (c *Conn) multiWrite(entries []Entry, ttl time.Duration) error {
+	strs := make([]string, len(entries))
+	for i, e := range entries {
+		strs[i] = string(e.Key)
+		if c.maxBytes > 0 && int64(len(strs[i])+len(e.Value)) > c.maxBytes {
+			return ErrValueTooLarge
+		}
+	}
+
+	var err error
+	var stored uint64
+	t := c.now()
+	c.shardRuns(strs, func(s *shard, idx []int) {
+		s.mu.Lock()
+		s.reapLocked(t)
+		for _, i := range idx {
+			el := newElement(entries[i].Value, t, 0, ttl)
+			el.version = atomic.AddUint64(&c.version, 1)
+			el, serr := c.setNamespacedLocked(s, strs[i], el)
+			if serr != nil {
+				if err == nil {
+					err = serr
+				}
+				continue
+			}
+			if lerr := c.logSet(strs[i], el); lerr != nil && err == nil {
+				err = lerr
+			}
+			stored++
+			c.evictLocked(s, strs[i])
+		}
+		s.mu.Unlock()
+	})
+	atomic.AddUint64(&c.stats.writes, stored)
+	return err
+}
@@ -0,0 +1,148 @@
+package memorystorecache
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiRead(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk), WithShards(4))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var keys [][]byte
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		keys = append(keys, key)
+		assert.Nil(t, conn.Write(key, []byte{byte(i)}))
+	}
+	assert.Nil(t, conn.WriteTTL([]byte("expiring"), []byte{1}, time.Second))
+	clk.Advance(time.Second)
+
+	found, err := conn.MultiRead(keys)
+	assert.Nil(t, err)
+	assert.Len(t, found, 20)
+	for i, k := range keys {
+		assert.Equal(t, []byte{byte(i)}, found[string(k)])
+	}
+
+	found, err = conn.MultiRead([][]byte{[]byte("key-1"), []byte("missing"), []byte("expiring")})
+	assert.Equal(t, map[string][]byte{"key-1": {1}}, found)
+	missing, ok := err.(*MissingKeysError)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, [][]byte{[]byte("missing"), []byte("expiring")}, missing.Keys)
+	assert.Equal(t, "2 keys not found", err.Error())
+
+	st := conn.TypedStats()
+	assert.Equal(t, uint64(21), st.Hits)
+	assert.Equal(t, uint64(2), st.Misses)
+	assert.Equal(t, uint64(1), st.ExpiredOnRead)
+}
+
+func TestMultiWrite(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk), WithShards(4))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var entries []Entry
+	for i := 0; i < 20; i++ {
+		entries = append(entries, Entry{Key: []byte(fmt.Sprintf("key-%d", i)), Value: []byte{byte(i)}})
+	}
+	assert.Nil(t, conn.MultiWrite(entries, time.Hour))
+	assert.Equal(t, uint64(20), conn.TypedStats().Writes)
+
+	for i, e := range entries {
+		b, err := conn.Read(e.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(i)}, b)
+		ttl, err := conn.RemainingTTL(e.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Hour, ttl)
+	}
+}
+
+func TestMultiWriteDuplicates(t *testing.T) {
+	c, err := NewCache(time.Minute, 0, WithShards(4))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// the last value for each key wins
+	var entries []Entry
+	for i := 0; i < 500; i++ {
+		entries = append(entries, Entry{Key: []byte(fmt.Sprintf("k%d", i%50)), Value: []byte(strconv.Itoa(i))})
+	}
+	assert.Nil(t, conn.MultiWrite(entries, time.Hour))
+
+	for i := 450; i < 500; i++ {
+		b, err := conn.Read([]byte(fmt.Sprintf("k%d", i%50)))
+		assert.Nil(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(b))
+	}
+}
+
+func TestMultiWriteTooLarge(t *testing.T) {
+	c, err := NewCache(time.Minute, 0, WithShards(1), WithMaxBytes(8))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	err = conn.MultiWrite([]Entry{
+		{Key: []byte("a"), Value: []byte{1}},
+		{Key: []byte("b"), Value: make([]byte, 16)},
+	}, time.Minute)
+	assert.Equal(t, ErrValueTooLarge, err)
+	assert.False(t, conn.Exists([]byte("a")))
+}
+
+func TestMultiWriteQuota(t *testing.T) {
+	c, err := NewCache(time.Minute, 0, WithShards(1))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	ns, err := conn.Namespace("ns", time.Minute, 8)
+	assert.Nil(t, err)
+	o := &testObserver{}
+	conn.SetObserver(o)
+
+	err = conn.MultiWrite([]Entry{
+		{Key: ns.key([]byte("a")), Value: []byte{1}},
+		{Key: ns.key([]byte("b")), Value: make([]byte, 16)},
+	}, time.Minute)
+	assert.Equal(t, ErrNamespaceQuota, err)
+	assert.True(t, ns.Exists([]byte("a")))
+	assert.False(t, ns.Exists([]byte("b")))
+	assert.Equal(t, uint64(1), conn.TypedStats().Writes)
+	assert.Equal(t, 1, o.writes)
+}
+
+func BenchmarkMultiRead(b *testing.B) {
+	c, _ := NewCache(time.Minute, 0)
+	conn, _ := c.Open("")
+	defer conn.Close()
+
+	keys := make([][]byte, 200)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+		conn.Write(keys[i], []byte("data"))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.MultiRead(keys)
+	}
+}
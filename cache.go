@@ -2,77 +2,538 @@ package memorystorecache
 
 import (
 	"errors"
+	"math/rand"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const numBuckets = 36
+// defaultShards is the number of shards used when WithShards is not given
+const defaultShards = 64
+
+// maxPendingExpired caps how many expired keys a shard queues for removal between writes
+const maxPendingExpired = 1024
+
+// ErrKeyNotFound is returned when a key is missing or expired
+var ErrKeyNotFound = errors.New("Key not found")
+
+// ErrValueTooLarge is returned when an entry does not fit in a shard's memory budget
+var ErrValueTooLarge = errors.New("Value exceeds the shard memory budget")
 
 // Cache contains memory store options
 // a TTL of 0 does not expire keys
 type Cache struct {
 	TTL        time.Duration
 	gcInterval time.Duration
+	shards     int
+	maxBytes   int64
+	maxEntries int
+	policy     PolicyFactory
+
+	negativeTTL time.Duration
+	refresher   func(key []byte) ([]byte, error)
+	earlyBeta   float64
+
+	appendLog       bool
+	fsync           FsyncPolicy
+	compactInterval time.Duration
+
+	clock      Clock
+	sweepLimit int
+
+	onEvict        func(key, value []byte, reason EvictReason)
+	evictQueueSize int
+
+	store           Store
+	storeInterval   time.Duration
+	storeBatch      int
+	storeMinBackoff time.Duration
+	storeMaxBackoff time.Duration
+}
+
+// Option configures a Cache
+type Option func(*Cache) error
+
+// WithShards sets the number of shards keys are spread across
+// n must be a power of two
+func WithShards(n int) Option {
+	return func(c *Cache) error {
+		if !isPowerOfTwo(n) {
+			return errors.New("shard count must be a power of two")
+		}
+		c.shards = n
+		return nil
+	}
+}
+
+// WithMaxBytes bounds the memory used by keys and values
+// the budget is split evenly between shards
+func WithMaxBytes(n int64) Option {
+	return func(c *Cache) error {
+		if n <= 0 {
+			return errors.New("max bytes must be positive")
+		}
+		c.maxBytes = n
+		return nil
+	}
+}
+
+// WithMaxEntries bounds the number of keys stored
+// the budget is split evenly between shards
+func WithMaxEntries(n int) Option {
+	return func(c *Cache) error {
+		if n <= 0 {
+			return errors.New("max entries must be positive")
+		}
+		c.maxEntries = n
+		return nil
+	}
+}
+
+// WithPolicy sets the eviction policy used when the cache is over budget
+// it defaults to NewLRU and only applies with WithMaxBytes or WithMaxEntries
+func WithPolicy(f PolicyFactory) Option {
+	return func(c *Cache) error {
+		if f == nil {
+			return errors.New("policy must not be nil")
+		}
+		c.policy = f
+		return nil
+	}
 }
 
 // Conn is a connection to a memory store db
 type Conn struct {
-	TTL    time.Duration
-	Dat    [numBuckets]map[string]cacheElement
-	mu     [numBuckets]sync.RWMutex
-	ticker *time.Ticker
+	TTL time.Duration
+	// stats follows an 8 byte field so its counters stay 64-bit aligned for sync/atomic on 32-bit platforms,
+	// structs in this module that hold atomic counters put them first for the same reason
+	stats counters
+	// version is the last version handed out, updated atomically
+	version uint64
+
+	shards     []shard
+	mask       uint64
+	maxBytes   int64 // per shard
+	maxEntries int   // per shard
+	policy     PolicyFactory
+	sweepLimit int
+	clock      Clock
+	ticker     Ticker
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	loads       loadGroup
+	negativeTTL time.Duration
+	refresher   func(key []byte) ([]byte, error)
+	earlyBeta   float64
+	random      func() float64 // draws early refreshes, rand.Float64 outside tests
+	aof         *appendLog
+	observer    atomic.Value // observerBox
+	evictions   *evictQueue
+	feed        *changeFeed
+	writeBehind *writeBehind
+
+	// namespaces holds a map[string]*Namespace, replaced as a whole under nsMu when one is added
+	namespaces atomic.Value
+	nsMu       sync.Mutex
+}
+
+// shard is a lock protected slice of the key space
+type shard struct {
+	mu    sync.RWMutex
+	dat   map[string]cacheElement
+	bytes int64
+
+	// pmu guards policy, which is also updated by readers holding only mu.RLock
+	pmu    sync.Mutex
+	policy Policy
+
+	// emu guards expired, keys that readers holding only mu.RLock found expired
+	// they are removed by the next writer or sweep of the shard
+	emu     sync.Mutex
+	expired []string
+
+	// tags maps a tag to the keys in the shard carrying it, nil until a tagged key is written
+	tags map[string]map[string]struct{}
+
+	// evictions receives entries leaving the shard, nil without an OnEvict callback
+	evictions *evictQueue
+	// feed receives every change to the shard for subscriptions
+	feed *changeFeed
+	// writeBehind queues writes and deletes for a Store, nil without WithWriteBehind
+	writeBehind *writeBehind
+
+	// expiries schedules keys with a TTL for removal by sweeps
+	expiries expiryHeap
 }
 
 type cacheElement struct {
-	expiresAt time.Time
+	expiresAt time.Time // the zero time never expires
+	staleAt   time.Time // the zero time is never stale
 	dat       []byte
+
+	// the TTLs the element was written with, reused when it is refreshed
+	ttl     time.Duration
+	softTTL time.Duration
+	// delta is how long the value took to load, used for early refreshes
+	delta time.Duration
+	// version changes every time the value is written
+	version uint64
+	// tags group the key for InvalidateTag
+	tags []string
+	// flags are opaque client flags, see WriteFlags
+	flags uint32
+	// fill marks a value that came from elsewhere, a loader, a refresher, a snapshot or the append log,
+	// rather than from a client write; fills are not queued for write behind
+	fill bool
+	// ns is the namespace the element's bytes are charged to, nil outside namespaces
+	ns *Namespace
+}
+
+// newElement builds an element written at t
+func newElement(v []byte, t time.Time, softTTL, ttl time.Duration) cacheElement {
+	el := cacheElement{dat: v, ttl: ttl, softTTL: softTTL}
+	el.setExpiry(t)
+	return el
+}
+
+// setExpiry sets the stale and expiry times from the element's TTLs, starting at t
+func (el *cacheElement) setExpiry(t time.Time) {
+	el.expiresAt = time.Time{}
+	el.staleAt = time.Time{}
+	// a 0 TTL leaves the zero time, which never expires
+	if el.ttl != 0 {
+		el.expiresAt = t.Add(el.ttl)
+	}
+	if el.softTTL != 0 {
+		el.staleAt = t.Add(el.softTTL)
+	}
+}
+
+// expired reports whether the element has expired at t
+func (el cacheElement) expired(t time.Time) bool {
+	return !el.expiresAt.IsZero() && !t.Before(el.expiresAt)
 }
 
-// Stats displays stats about the memory store
-type Stats map[string]interface{}
+// size is the number of bytes an element counts against the memory budget
+func (el cacheElement) size(key string) int64 {
+	return int64(len(key) + len(el.dat))
+}
 
 // NewCache creates a new Cache
 // gcInterval is the interval at which to perform garbage collection
 // if gcInterval is set to 0, there will be no garbage collection
-func NewCache(defaultTimeout, gcInterval time.Duration) (*Cache, error) {
-	return &Cache{TTL: defaultTimeout, gcInterval: gcInterval}, nil
+func NewCache(defaultTimeout, gcInterval time.Duration, opts ...Option) (*Cache, error) {
+	c := &Cache{
+		TTL:             defaultTimeout,
+		gcInterval:      gcInterval,
+		shards:          defaultShards,
+		compactInterval: time.Minute,
+		clock:           systemClock{},
+		sweepLimit:      defaultSweepLimit,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 // Open opens a new connection to the memory store
+// name is the data directory when WithAppendLog is set, and is otherwise ignored
+// use Conn.Namespace to share one connection's memory between separate key spaces
 func (c Cache) Open(name string) (*Conn, error) {
-	var m Conn
-	for i := 0; i < numBuckets; i++ {
-		d := map[string]cacheElement{}
-		m.Dat[i] = d
+	n := c.shards
+	if n == 0 {
+		n = defaultShards
+	}
+
+	m := Conn{
+		TTL:         c.TTL,
+		shards:      make([]shard, n),
+		mask:        uint64(n - 1),
+		negativeTTL: c.negativeTTL,
+		refresher:   c.refresher,
+		earlyBeta:   c.earlyBeta,
+		random:      rand.Float64,
+		clock:       c.clock,
+		sweepLimit:  c.sweepLimit,
+		done:        make(chan struct{}),
+		feed:        &changeFeed{},
+	}
+	if m.sweepLimit == 0 {
+		m.sweepLimit = defaultSweepLimit
+	}
+	if m.clock == nil {
+		m.clock = systemClock{}
+	}
+	// start versions at the current time so versions from before a restart are not handed out again
+	m.version = uint64(m.clock.Now().UnixNano())
+	if c.maxBytes > 0 || c.maxEntries > 0 {
+		m.maxBytes = perShard(c.maxBytes, n)
+		m.maxEntries = int(perShard(int64(c.maxEntries), n))
+		m.policy = c.policy
+		if m.policy == nil {
+			m.policy = NewLRU
+		}
+	}
+	for i := range m.shards {
+		m.shards[i].dat = map[string]cacheElement{}
+		m.shards[i].feed = m.feed
+		if m.policy != nil {
+			m.shards[i].policy = m.policy()
+		}
+	}
+
+	if c.appendLog {
+		if err := m.openAppendLog(name, c.fsync, c.compactInterval); err != nil {
+			return nil, err
+		}
+	}
+	// keys replaced while replaying the log are not reported, nor written behind
+	if c.onEvict != nil {
+		m.evictions = newEvictQueue(c.onEvict, c.evictQueueSize)
+		for i := range m.shards {
+			m.shards[i].evictions = m.evictions
+		}
+	}
+	if c.store != nil {
+		m.writeBehind = newWriteBehind(c, m.clock)
+		for i := range m.shards {
+			m.shards[i].writeBehind = m.writeBehind
+		}
 	}
 
 	// only garbage collect if gcInterval > 0
 	if c.gcInterval > 0 {
 		// start the sweep ticker
-		m.ticker = time.NewTicker(c.gcInterval)
+		m.ticker = m.clock.NewTicker(c.gcInterval)
 		go func(cn *Conn) {
-			for range m.ticker.C {
-				cn.sweep()
+			for {
+				select {
+				case <-cn.ticker.C():
+					cn.sweep()
+				case <-cn.done:
+					return
+				}
 			}
 		}(&m)
 	}
 
-	m.TTL = c.TTL
 	return &m, nil
 }
 
-// Close noop (there is no connection to close)
+// Close stops garbage collection and releases all keys
+// closing a connection more than once is a noop
 func (c *Conn) Close() error {
-	c.ticker.Stop()
-	c.deallocate()
-	return nil
+	var err error
+	c.closeOnce.Do(func() {
+		if c.ticker != nil {
+			c.ticker.Stop()
+		}
+		close(c.done)
+		if c.writeBehind != nil {
+			err = c.writeBehind.close()
+		}
+		if c.aof != nil {
+			if aerr := c.aof.close(); err == nil {
+				err = aerr
+			}
+		}
+		c.deallocate()
+		c.feed.close()
+	})
+	return err
 }
 
 func (c *Conn) deallocate() {
-	for i := range c.Dat {
-		for k := range c.Dat[i] {
-			delete(c.Dat[i], k)
+	// released keys are delivered after the shard locks, waiting for room in the queue rather than dropping them
+	var last []eviction
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		q := s.evictions
+		s.evictions = nil
+		for k, el := range s.dat {
+			s.removeLocked(k, EvictClosed)
+			if q != nil {
+				last = append(last, eviction{key: k, value: el.dat, reason: EvictClosed})
+			}
+		}
+		s.expiries = nil
+		s.mu.Unlock()
+	}
+	if c.evictions != nil {
+		c.evictions.close(last)
+	}
+}
+
+// now is the current time in UTC according to the connection's clock
+func (c *Conn) now() time.Time {
+	return c.clock.Now().UTC()
+}
+
+// Now is the current time according to the connection's clock,
+// servers use it to turn absolute expiry times into TTLs that agree with the cache
+func (c *Conn) Now() time.Time {
+	return c.now()
+}
+
+func (c *Conn) shardFor(key string) *shard {
+	return &c.shards[keyToShard(key, c.mask)]
+}
+
+// perShard splits a budget between n shards, rounding up
+func perShard(total int64, n int) int64 {
+	if total <= 0 {
+		return 0
+	}
+	return (total + int64(n) - 1) / int64(n)
+}
+
+// access records a read hit with the shard's eviction policy
+func (s *shard) access(key string) {
+	if s.policy == nil {
+		return
+	}
+	s.pmu.Lock()
+	s.policy.Access(key)
+	s.pmu.Unlock()
+}
+
+// setLocked stores an element, the caller must hold the write lock
+func (s *shard) setLocked(key string, el cacheElement) {
+	old, exists := s.dat[key]
+	if exists {
+		s.bytes -= old.size(key)
+		s.untagLocked(key, old)
+		old.ns.charge(-old.size(key))
+		s.notifyLocked(key, old, EvictOverwritten)
+	}
+	s.dat[key] = el
+	s.bytes += el.size(key)
+	el.ns.charge(el.size(key))
+	s.feed.publish(EventSet, key, el.dat)
+	if !el.fill {
+		s.markLocked(key, el)
+	}
+	s.trackExpiry(key, el)
+	s.tagLocked(key, el)
+
+	if s.policy != nil {
+		s.pmu.Lock()
+		if exists {
+			s.policy.Access(key)
+		} else {
+			s.policy.Add(key)
 		}
+		s.pmu.Unlock()
+	}
+}
+
+// markExpired queues an expired key for removal without taking the write lock
+func (s *shard) markExpired(key string) {
+	s.emu.Lock()
+	if len(s.expired) < maxPendingExpired {
+		s.expired = append(s.expired, key)
+	}
+	s.emu.Unlock()
+}
+
+// reapLocked removes queued keys that are still expired at t, returning how many it removed
+// the caller must hold the write lock
+func (s *shard) reapLocked(t time.Time) uint64 {
+	s.emu.Lock()
+	keys := s.expired
+	s.expired = nil
+	s.emu.Unlock()
+
+	var n uint64
+	for _, key := range keys {
+		// the key may have been rewritten since it was queued
+		if el, exists := s.dat[key]; exists && el.expired(t) {
+			s.removeLocked(key, EvictExpiredOnRead)
+			n++
+		}
+	}
+	return n
+}
+
+// removeLocked deletes a key, reporting it to the OnEvict callback with reason
+// the caller must hold the write lock
+func (s *shard) removeLocked(key string, reason EvictReason) bool {
+	el, exists := s.dat[key]
+	if !exists {
+		return false
+	}
+	delete(s.dat, key)
+	s.bytes -= el.size(key)
+	s.untagLocked(key, el)
+	el.ns.charge(-el.size(key))
+	s.notifyLocked(key, el, reason)
+	if typ, ok := eventFor(reason); ok {
+		s.feed.publish(typ, key, nil)
+	}
+
+	if s.policy != nil {
+		s.pmu.Lock()
+		s.policy.Remove(key)
+		s.pmu.Unlock()
+	}
+	return true
+}
+
+// evictLocked evicts keys until the shard is within budget
+// keep is never evicted so a write cannot evict the key it just stored
+// the caller must hold the write lock
+func (c *Conn) evictLocked(s *shard, keep string) {
+	if s.policy == nil {
+		return
+	}
+
+	spared := false
+	for {
+		var cause *uint64
+		switch {
+		case c.maxBytes > 0 && s.bytes > c.maxBytes:
+			cause = &c.stats.evictedBytes
+		case c.maxEntries > 0 && len(s.dat) > c.maxEntries:
+			cause = &c.stats.evictedEntries
+		}
+		if cause == nil {
+			break
+		}
+
+		s.pmu.Lock()
+		victim, ok := s.policy.Evict()
+		s.pmu.Unlock()
+		if !ok {
+			break
+		}
+		if victim == keep {
+			spared = true
+			continue
+		}
+
+		if el, exists := s.dat[victim]; exists {
+			delete(s.dat, victim)
+			s.bytes -= el.size(victim)
+			s.untagLocked(victim, el)
+			el.ns.charge(-el.size(victim))
+			s.notifyLocked(victim, el, EvictCapacity)
+			s.feed.publish(EventEvicted, victim, nil)
+			atomic.AddUint64(cause, 1)
+			// a failed log write is returned by the next write
+			c.logDelete(victim)
+		}
+	}
+
+	if spared {
+		s.pmu.Lock()
+		s.policy.Add(keep)
+		s.pmu.Unlock()
 	}
 }
 
@@ -84,86 +545,297 @@ func (c *Conn) Write(k, v []byte) error {
 // WriteTTL writes data to the cache with an explicit TTL
 // a TTL of 0 does not expire keys
 func (c *Conn) WriteTTL(k, v []byte, ttl time.Duration) error {
-	key := string(k)
-	idx := keyToShard(key)
-	var e time.Time
-
-	if ttl == 0 {
-		// for a 0 TTL, store the max value of a time struct, so it essentially never expires
-		e = time.Unix(1<<63-1, 0)
-	} else {
-		e = time.Now().UTC().Add(ttl)
+	o := c.loadObserver()
+	if o == nil {
+		return c.writeElement(string(k), newElement(v, c.now(), 0, ttl))
 	}
+	start := time.Now()
+	err := c.writeElement(string(k), newElement(v, c.now(), 0, ttl))
+	o.ObserveWrite(time.Since(start))
+	return err
+}
+
+// writeElement stores an element, evicting other keys if its shard is over budget
+func (c *Conn) writeElement(key string, ce cacheElement) error {
+	_, err := c.writeElementIf(key, ce, nil)
+	return err
+}
+
+// writeCond decides under the shard lock whether a write goes ahead, given the key's current element
+// exists is false for a missing or expired key
+type writeCond func(cur cacheElement, exists bool) error
 
-	ce := cacheElement{
-		expiresAt: e,
-		dat:       v,
+// writeElementIf stores an element if cond allows it, returning the element's new version
+func (c *Conn) writeElementIf(key string, ce cacheElement, cond writeCond) (uint64, error) {
+	if c.maxBytes > 0 && ce.size(key) > c.maxBytes {
+		return 0, ErrValueTooLarge
 	}
+	el, err := c.updateElement(key, func(cur cacheElement, exists bool, t time.Time) (cacheElement, error) {
+		if cond != nil {
+			if err := cond(cur, exists); err != nil {
+				return cacheElement{}, err
+			}
+		}
+		return ce, nil
+	})
+	return el.version, err
+}
 
-	c.mu[idx].Lock()
-	c.Dat[idx][key] = ce
-	c.mu[idx].Unlock()
+// updateElement stores the element fn builds from a key's current element under the shard lock
+// exists is false for a missing or expired key, and t is the time of the write
+func (c *Conn) updateElement(key string, fn func(cur cacheElement, exists bool, t time.Time) (cacheElement, error)) (cacheElement, error) {
+	s := c.shardFor(key)
+	t := c.now()
 
-	return nil
+	s.mu.Lock()
+	s.reapLocked(t)
+	cur, exists := s.dat[key]
+	el, err := fn(cur, exists && !cur.expired(t), t)
+	if err != nil {
+		s.mu.Unlock()
+		return cacheElement{}, err
+	}
+	if c.maxBytes > 0 && el.size(key) > c.maxBytes {
+		s.mu.Unlock()
+		return cacheElement{}, ErrValueTooLarge
+	}
+	el.version = atomic.AddUint64(&c.version, 1)
+	el, err = c.setNamespacedLocked(s, key, el)
+	if err != nil {
+		s.mu.Unlock()
+		return cacheElement{}, err
+	}
+	err = c.logSet(key, el)
+	c.evictLocked(s, key)
+	s.mu.Unlock()
+	atomic.AddUint64(&c.stats.writes, 1)
+
+	return el, err
 }
 
 // Read retrieves data for a key from the cache
 func (c *Conn) Read(k []byte) ([]byte, error) {
+	o := c.loadObserver()
+	if o == nil {
+		return c.read(k)
+	}
+	start := time.Now()
+	v, err := c.read(k)
+	o.ObserveRead(time.Since(start), err == nil)
+	return v, err
+}
+
+func (c *Conn) read(k []byte) ([]byte, error) {
+	el, err := c.readElement(k)
+	if err != nil {
+		return []byte{}, err
+	}
+	return el.dat, nil
+}
+
+// readElement reads the unexpired element of a key, recording a hit or miss
+func (c *Conn) readElement(k []byte) (cacheElement, error) {
 	key := string(k)
-	idx := keyToShard(key)
+	s := c.shardFor(key)
 
-	c.mu[idx].RLock()
-	el, exists := c.Dat[idx][key]
-	c.mu[idx].RUnlock()
-	if exists && time.Now().UTC().Before(el.expiresAt) {
-		return el.dat, nil
+	s.mu.RLock()
+	el, exists := s.dat[key]
+	s.mu.RUnlock()
+	t := c.now()
+	if exists && !el.expired(t) {
+		atomic.AddUint64(&c.stats.hits, 1)
+		s.access(key)
+		c.maybeRefresh(key, el, t)
+		return el, nil
 	} else if exists {
-		// evict key since it exists and it's expired
-		c.mu[idx].Lock()
-		delete(c.Dat[idx], key)
-		c.mu[idx].Unlock()
+		atomic.AddUint64(&c.stats.expiredOnRead, 1)
+		// queue the key for eviction rather than waiting on the write lock
+		s.markExpired(key)
 	}
-	return []byte{}, errors.New("Key not found")
+	atomic.AddUint64(&c.stats.misses, 1)
+	return cacheElement{}, ErrKeyNotFound
 }
 
-func (c *Conn) keyCount() uint64 {
-	var x uint64
-	for i := range c.Dat {
-		x += uint64(len(c.Dat[i]))
+// peek returns the unexpired value of a key without recording a hit or miss
+func (c *Conn) peek(key string) ([]byte, bool) {
+	s := c.shardFor(key)
+	s.mu.RLock()
+	el, exists := s.dat[key]
+	s.mu.RUnlock()
+	if !exists || el.expired(c.now()) {
+		return nil, false
 	}
-	return x
+	return el.dat, true
 }
 
-// Stats provides stats about the Badger database
-func (c *Conn) Stats() (map[string]interface{}, error) {
-	return Stats{
-		"KeyCount": c.keyCount(),
-	}, nil
+// Exists reports whether a key is in the cache and unexpired, without copying its data
+func (c *Conn) Exists(k []byte) bool {
+	key := string(k)
+	s := c.shardFor(key)
+
+	s.mu.RLock()
+	el, exists := s.dat[key]
+	s.mu.RUnlock()
+	if exists && el.expired(c.now()) {
+		s.markExpired(key)
+		return false
+	}
+	return exists
 }
 
-func (c *Conn) sweep() {
-	for i := 0; i < numBuckets; i++ {
-		go c.sweepBucket(i)
+// Delete removes a key from the cache
+// ErrKeyNotFound is returned if the key is missing or expired
+func (c *Conn) Delete(k []byte) error {
+	key := string(k)
+	s := c.shardFor(key)
+	t := c.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reapLocked(t)
+	el, exists := s.dat[key]
+	if !exists {
+		return ErrKeyNotFound
+	}
+	if el.expired(t) {
+		// the key was already gone, so it is reported as expired rather than deleted
+		s.removeLocked(key, EvictExpiredOnRead)
+		return ErrKeyNotFound
+	}
+	s.removeLocked(key, EvictDeleted)
+	s.markDeletedLocked(key)
+	atomic.AddUint64(&c.stats.deletes, 1)
+	return c.logDelete(key)
+}
+
+// Touch resets the TTL of a key to the default cache TTL
+func (c *Conn) Touch(k []byte) error {
+	return c.TouchTTL(k, c.TTL)
+}
+
+// TouchTTL resets the TTL of a key to an explicit TTL
+// a TTL of 0 does not expire the key
+func (c *Conn) TouchTTL(k []byte, ttl time.Duration) error {
+	key := string(k)
+	s := c.shardFor(key)
+	t := c.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reapLocked(t)
+	el, exists := s.dat[key]
+	if !exists || el.expired(t) {
+		return ErrKeyNotFound
+	}
+	el.ttl = ttl
+	el.setExpiry(t)
+	s.dat[key] = el
+	s.trackExpiry(key, el)
+	s.markLocked(key, el)
+	return c.logSet(key, el)
+}
+
+// RemainingTTL returns how long a key has left before it expires
+// a key that never expires has a remaining TTL of 0
+func (c *Conn) RemainingTTL(k []byte) (time.Duration, error) {
+	key := string(k)
+	s := c.shardFor(key)
+	t := c.now()
+
+	s.mu.RLock()
+	el, exists := s.dat[key]
+	s.mu.RUnlock()
+	if !exists {
+		return 0, ErrKeyNotFound
+	}
+	if el.expired(t) {
+		s.markExpired(key)
+		return 0, ErrKeyNotFound
+	}
+	if el.expiresAt.IsZero() {
+		return 0, nil
 	}
+	return el.expiresAt.Sub(t), nil
 }
 
-func (c *Conn) sweepBucket(idx int) {
-	t := time.Now().UTC()
+// Flush removes every key from the cache
+func (c *Conn) Flush() error {
+	return c.flushPrefix("")
+}
 
-	c.mu[idx].RLock()
-	// pre-allocate the memory
-	// we will store at most the total number of keys
-	keys := make([]string, 0, len(c.Dat[idx]))
-	for k, v := range c.Dat[idx] {
-		if t.After(v.expiresAt) {
-			keys = append(keys, k)
+// flushPrefix removes every key starting with prefix
+func (c *Conn) flushPrefix(prefix string) error {
+	var err error
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		for key := range s.dat {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			s.removeLocked(key, EvictDeleted)
+			if lerr := c.logDelete(key); lerr != nil && err == nil {
+				err = lerr
+			}
 		}
+		if prefix == "" {
+			s.expiries = nil
+		}
+		s.mu.Unlock()
 	}
-	c.mu[idx].RUnlock()
+	return err
+}
 
-	for _, key := range keys {
-		c.mu[idx].Lock()
-		delete(c.Dat[idx], key)
-		c.mu[idx].Unlock()
+// Keys returns the unexpired keys starting with prefix, in no particular order
+func (c *Conn) Keys(prefix []byte) [][]byte {
+	p := string(prefix)
+	t := c.now()
+
+	var keys [][]byte
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		for key, el := range s.dat {
+			if strings.HasPrefix(key, p) && !el.expired(t) {
+				keys = append(keys, []byte(key))
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return keys
+}
+
+func (c *Conn) sweep() {
+	start := time.Now()
+	atomic.AddUint64(&c.stats.sweepRuns, 1)
+	c.loads.sweepNegatives(c.now())
+
+	wg := sync.WaitGroup{}
+	var removed uint64
+	for i := range c.shards {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			atomic.AddUint64(&removed, c.sweepBucket(idx))
+		}(i)
 	}
+	wg.Wait()
+
+	atomic.AddUint64(&c.stats.sweepRemoved, removed)
+	if o := c.loadObserver(); o != nil {
+		o.ObserveSweep(time.Since(start), removed)
+	}
+}
+
+// sweepBucket removes the expired keys of a shard, returning how many it removed
+// expired keys are found through the shard's expiry heap and removed under a single lock
+func (c *Conn) sweepBucket(idx int) uint64 {
+	t := c.now()
+	s := &c.shards[idx]
+
+	s.mu.Lock()
+	removed := s.reapLocked(t)
+	removed += s.expireLocked(t, c.sweepLimit)
+	s.mu.Unlock()
+	return removed
 }
@@ -1,7 +1,9 @@
 package memorystorecache
 
 import (
+	"fmt"
 	"runtime"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -15,10 +17,35 @@ func TestNewCache(t *testing.T) {
 	assert.Nil(t, err)
 
 	assert.Equal(t, time.Second, c.TTL)
+	assert.Equal(t, defaultShards, c.shards)
+}
+
+func TestNewCacheWithShards(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second, WithShards(8))
+	assert.Nil(t, err)
+	assert.Equal(t, 8, c.shards)
+
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Len(t, conn.shards, 8)
+
+	_, err = NewCache(time.Second, time.Second, WithShards(10))
+	assert.EqualError(t, err, "shard count must be a power of two")
+}
+
+func TestCloseWithoutGC(t *testing.T) {
+	c, err := NewCache(time.Second, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+
+	assert.Nil(t, conn.Close())
 }
 
 func TestWrite(t *testing.T) {
-	c, err := NewCache(time.Second, time.Second)
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, time.Second, WithClock(clk))
 	assert.Nil(t, err)
 	conn, err := c.Open("")
 	assert.Nil(t, err)
@@ -37,13 +64,14 @@ func TestWrite(t *testing.T) {
 	assert.Equal(t, b, b2)
 
 	// default ttl timeout (cache miss)
-	time.Sleep(time.Second)
+	clk.Advance(time.Second)
 	_, err = conn.Read(key)
 	assert.Errorf(t, err, "Key not found")
 }
 
 func TestWriteTTL(t *testing.T) {
-	c, err := NewCache(time.Second, time.Second)
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, time.Second, WithClock(clk))
 	assert.Nil(t, err)
 	conn, err := c.Open("")
 	assert.Nil(t, err)
@@ -62,11 +90,30 @@ func TestWriteTTL(t *testing.T) {
 	assert.Equal(t, b, b2)
 
 	// default ttl timeout (cache miss)
-	time.Sleep(time.Second)
+	clk.Advance(time.Second)
 	_, err = conn.Read(key)
 	assert.Errorf(t, err, "Key not found")
 }
 
+func TestWriteTTLZero(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, time.Second, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("forever")
+	err = conn.WriteTTL(key, []byte{1}, 0)
+	assert.Nil(t, err)
+
+	// a 0 TTL never expires
+	clk.Advance(time.Second)
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+}
+
 func TestRead(t *testing.T) {
 	c, err := NewCache(time.Second, time.Second)
 	assert.Nil(t, err)
@@ -89,22 +136,256 @@ func TestRead(t *testing.T) {
 	assert.Equal(t, v, b)
 }
 
-func TestStats(t *testing.T) {
-	c, err := NewCache(time.Second, time.Second)
+func TestReadQueuesExpiredKey(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, 0, WithShards(1), WithClock(clk))
 	assert.Nil(t, err)
 	conn, err := c.Open("")
 	assert.Nil(t, err)
 	defer conn.Close()
 
-	// write a key
-	key := []byte("my-key")
-	v := []byte{1, 2}
-	err = conn.Write(key, v)
+	key := []byte("key")
+	err = conn.WriteTTL(key, []byte{1}, 100*time.Millisecond)
+	assert.Nil(t, err)
+	clk.Advance(100 * time.Millisecond)
+
+	// the expired key is queued rather than removed by the reader
+	_, err = conn.Read(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, []string{"key"}, conn.shards[0].expired)
+	assert.Equal(t, uint64(1), conn.keyCount())
+
+	// and removed by the next write to the shard
+	err = conn.Write([]byte("other"), []byte{2})
+	assert.Nil(t, err)
+	assert.Empty(t, conn.shards[0].expired)
+	assert.Equal(t, uint64(1), conn.keyCount())
+}
+
+func TestExists(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	assert.False(t, conn.Exists(key))
+
+	err = conn.WriteTTL(key, []byte{1}, 100*time.Millisecond)
 	assert.Nil(t, err)
+	assert.True(t, conn.Exists(key))
+
+	clk.Advance(100 * time.Millisecond)
+	assert.False(t, conn.Exists(key))
+}
+
+func TestDelete(t *testing.T) {
+	c, err := NewCache(time.Second, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	assert.Equal(t, ErrKeyNotFound, conn.Delete(key))
+
+	err = conn.Write(key, []byte{1})
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Delete(key))
+
+	_, err = conn.Read(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint64(0), conn.keyCount())
+}
+
+func TestTouch(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(200*time.Millisecond, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	assert.Equal(t, ErrKeyNotFound, conn.Touch(key))
+
+	err = conn.WriteTTL(key, []byte{1}, 100*time.Millisecond)
+	assert.Nil(t, err)
+	// extend the key to the default TTL
+	assert.Nil(t, conn.Touch(key))
+	clk.Advance(100 * time.Millisecond)
+	assert.True(t, conn.Exists(key))
+
+	// remove the expiry altogether
+	assert.Nil(t, conn.TouchTTL(key, 0))
+	ttl, err := conn.RemainingTTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+func TestRemainingTTL(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	_, err = conn.RemainingTTL(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = conn.WriteTTL(key, []byte{1}, time.Minute)
+	assert.Nil(t, err)
+	ttl, err := conn.RemainingTTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	clk.Advance(time.Second)
+	ttl, err = conn.RemainingTTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 59*time.Second, ttl)
+}
+
+func TestKeys(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.Write([]byte("user:1"), []byte{1}))
+	assert.Nil(t, conn.Write([]byte("user:2"), []byte{1}))
+	assert.Nil(t, conn.WriteTTL([]byte("user:3"), []byte{1}, time.Second))
+	assert.Nil(t, conn.Write([]byte("episode:1"), []byte{1}))
+	clk.Advance(2 * time.Second)
+
+	keys := conn.Keys([]byte("user:"))
+	sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+	assert.Equal(t, [][]byte{[]byte("user:1"), []byte("user:2")}, keys)
+	assert.Len(t, conn.Keys(nil), 3)
+}
+
+func TestFlush(t *testing.T) {
+	c, err := NewCache(time.Minute, 0, WithMaxEntries(100))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte{1}))
+	}
+	assert.Nil(t, conn.Flush())
+	assert.Equal(t, uint64(0), conn.keyCount())
+	assert.Equal(t, int64(0), conn.TypedStats().Bytes)
+	assert.False(t, conn.Exists([]byte("key-0")))
+
+	// the cache is usable after a flush
+	assert.Nil(t, conn.Write([]byte("key-0"), []byte{2}))
+	assert.True(t, conn.Exists([]byte("key-0")))
+}
+
+func TestSweep(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, time.Minute, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 100; i++ {
+		err = conn.WriteTTL([]byte(fmt.Sprintf("key-%d", i)), []byte{1}, time.Duration(i+1)*time.Second)
+		assert.Nil(t, err)
+	}
+
+	// the gc interval elapsing sweeps the expired keys
+	clk.Advance(30 * time.Second)
+	assert.Equal(t, uint64(100), conn.keyCount())
+	clk.Advance(30 * time.Second)
+	waitFor(t, func() bool {
+		return conn.keyCount() == 40
+	})
+	assert.Equal(t, uint64(60), conn.TypedStats().SweepRemoved)
+}
+
+func TestMaxEntries(t *testing.T) {
+	c, err := NewCache(0, 0, WithShards(1), WithMaxEntries(2))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1}))
+	assert.Nil(t, conn.Write([]byte("b"), []byte{2}))
+	// touch a so b is the least recently used key
+	_, err = conn.Read([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Write([]byte("c"), []byte{3}))
+
+	_, err = conn.Read([]byte("b"))
+	assert.EqualError(t, err, "Key not found")
+	_, err = conn.Read([]byte("a"))
+	assert.Nil(t, err)
+	_, err = conn.Read([]byte("c"))
+	assert.Nil(t, err)
+
+	s, err := conn.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), s["KeyCount"])
+	assert.Equal(t, uint64(1), s["EvictionsMaxEntries"])
+}
+
+func TestMaxBytes(t *testing.T) {
+	c, err := NewCache(0, 0, WithShards(1), WithMaxBytes(10), WithPolicy(NewLFU))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// each entry is 5 bytes
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1, 2, 3, 4}))
+	assert.Nil(t, conn.Write([]byte("b"), []byte{1, 2, 3, 4}))
+	_, err = conn.Read([]byte("b"))
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Write([]byte("c"), []byte{1, 2, 3, 4}))
+
+	_, err = conn.Read([]byte("a"))
+	assert.EqualError(t, err, "Key not found")
+	assert.Equal(t, int64(10), conn.shards[0].bytes)
+
+	// overwriting a key replaces its size
+	assert.Nil(t, conn.Write([]byte("c"), []byte{1}))
+	assert.Equal(t, int64(7), conn.shards[0].bytes)
 
 	s, err := conn.Stats()
 	assert.Nil(t, err)
-	assert.Equal(t, map[string]interface{}{"KeyCount": uint64(1)}, s)
+	assert.Equal(t, uint64(1), s["EvictionsMaxBytes"])
+
+	assert.Equal(t, ErrValueTooLarge, conn.Write([]byte("d"), make([]byte, 10)))
+}
+
+func TestMaxBytesOptions(t *testing.T) {
+	_, err := NewCache(0, 0, WithMaxBytes(0))
+	assert.EqualError(t, err, "max bytes must be positive")
+	_, err = NewCache(0, 0, WithMaxEntries(-1))
+	assert.EqualError(t, err, "max entries must be positive")
+	_, err = NewCache(0, 0, WithPolicy(nil))
+	assert.EqualError(t, err, "policy must not be nil")
+}
+
+// waitFor polls cond until it is true, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 }
 
 func writeData(c *Conn, numKeys int) {
@@ -183,3 +464,58 @@ func BenchmarkRead(b *testing.B) {
 		conn.Read(uuid.NewV4().Bytes())
 	}
 }
+
+func writePrefixedData(c *Conn, prefix string, numKeys int) {
+	wg := sync.WaitGroup{}
+	numWorkers := runtime.NumCPU()
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(c *Conn, worker, keys int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				c.Write([]byte(fmt.Sprintf("%s%d:%d", prefix, worker, i)), uuid.NewV4().Bytes())
+			}
+		}(c, i, numKeys/numWorkers)
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkWritePrefixedWorkers(b *testing.B) {
+	c, _ := NewCache(time.Second, time.Second)
+	conn, _ := c.Open("")
+	defer conn.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		writePrefixedData(conn, "episode:", 1000000)
+	}
+}
+
+func readPrefixedData(c *Conn, prefix string, numKeys int) {
+	wg := sync.WaitGroup{}
+	numWorkers := runtime.NumCPU()
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(c *Conn, worker, keys int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				c.Read([]byte(fmt.Sprintf("%s%d:%d", prefix, worker, i)))
+			}
+		}(c, i, numKeys/numWorkers)
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkGetPrefixedWorkers(b *testing.B) {
+	c, _ := NewCache(time.Second, time.Second)
+	conn, _ := c.Open("")
+	defer conn.Close()
+
+	writePrefixedData(conn, "episode:", 1000000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readPrefixedData(conn, "episode:", 1000000)
+	}
+}
@@ -0,0 +1,126 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Clock tells the time for expiry checks and schedules garbage collection
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks created by a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WithClock sets the Clock used for expiry and garbage collection, it defaults to the system clock
+func WithClock(clk Clock) Option {
+	return func(c *Cache) error {
+		if clk == nil {
+			return errors.New("clock must not be nil")
+		}
+		c.clock = clk
+		return nil
+	}
+}
+
+// systemClock is the Clock backed by the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTicker) Stop() {
+	t.t.Stop()
+}
+
+// FakeClock is a Clock that only moves when Advance is called, for deterministic tests
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock creates a FakeClock set to t
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the fake time
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the fake time forward by d, firing any tickers that come due
+// like time.Ticker, a ticker that is not being read drops ticks rather than blocking
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+
+	live := f.tickers[:0]
+	for _, t := range f.tickers {
+		if t.stopped {
+			continue
+		}
+		live = append(live, t)
+		for !t.next.After(f.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.d)
+		}
+	}
+	f.tickers = live
+}
+
+// NewTicker creates a ticker that fires as the fake time is advanced
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{clock: f, c: make(chan time.Time, 1), d: d, next: f.now.Add(d)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+type fakeTicker struct {
+	clock   *FakeClock
+	c       chan time.Time
+	d       time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	t.stopped = true
+	t.clock.mu.Unlock()
+}
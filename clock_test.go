@@ -0,0 +1,57 @@
+package memorystorecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+	assert.Equal(t, start, clk.Now())
+
+	clk.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), clk.Now())
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+	tk := clk.NewTicker(time.Second)
+
+	// not due yet
+	clk.Advance(500 * time.Millisecond)
+	select {
+	case <-tk.C():
+		t.Fatal("ticker fired early")
+	default:
+	}
+
+	clk.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-tk.C())
+
+	// ticks that are not read are dropped
+	clk.Advance(3 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-tk.C())
+	select {
+	case <-tk.C():
+		t.Fatal("ticker did not drop ticks")
+	default:
+	}
+
+	// stopped tickers never fire
+	tk.Stop()
+	clk.Advance(time.Minute)
+	select {
+	case <-tk.C():
+		t.Fatal("stopped ticker fired")
+	default:
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	_, err := NewCache(time.Second, 0, WithClock(nil))
+	assert.EqualError(t, err, "clock must not be nil")
+}
@@ -0,0 +1,126 @@
+// Command memorystore-server serves a memory store over the Redis protocol on -addr,
+// and optionally over the memcached text protocol on -memcache-addr and an HTTP admin API on -http-addr
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+	"github.com/panoplymedia/local-cache-memorystore/server/httpapi"
+	"github.com/panoplymedia/local-cache-memorystore/server/memcache"
+	"github.com/panoplymedia/local-cache-memorystore/server/resp"
+)
+
+func main() {
+	var (
+		addr         = flag.String("addr", ":6379", "address to serve the redis protocol on")
+		memcacheAddr = flag.String("memcache-addr", "", "address to serve the memcached protocol on, disabled if empty")
+		httpAddr     = flag.String("http-addr", "", "address to serve the HTTP admin API on, disabled if empty")
+		ttl          = flag.Duration("ttl", time.Hour, "default TTL for keys set without EX or PX")
+		gc           = flag.Duration("gc", time.Minute, "garbage collection interval, 0 disables it")
+		shards       = flag.Int("shards", 64, "number of shards, a power of two")
+		maxBytes     = flag.Int64("max-bytes", 0, "memory budget for keys and values, 0 is unlimited")
+		policy       = flag.String("policy", "lru", "eviction policy: lru, lfu or sieve")
+		dataDir      = flag.String("data-dir", "", "persist writes to an append log in this directory")
+		fsync        = flag.String("fsync", "everysec", "append log fsync policy: always, everysec or never")
+	)
+	flag.Parse()
+
+	opts := []memorystorecache.Option{memorystorecache.WithShards(*shards)}
+	if *maxBytes > 0 {
+		opts = append(opts, memorystorecache.WithMaxBytes(*maxBytes))
+	}
+	switch *policy {
+	case "lru":
+		opts = append(opts, memorystorecache.WithPolicy(memorystorecache.NewLRU))
+	case "lfu":
+		opts = append(opts, memorystorecache.WithPolicy(memorystorecache.NewLFU))
+	case "sieve":
+		opts = append(opts, memorystorecache.WithPolicy(memorystorecache.NewSIEVE))
+	default:
+		fatalf("unknown eviction policy %q", *policy)
+	}
+	if *dataDir != "" {
+		p, ok := map[string]memorystorecache.FsyncPolicy{
+			"always":   memorystorecache.FsyncAlways,
+			"everysec": memorystorecache.FsyncEverySecond,
+			"never":    memorystorecache.FsyncNever,
+		}[*fsync]
+		if !ok {
+			fatalf("unknown fsync policy %q", *fsync)
+		}
+		opts = append(opts, memorystorecache.WithAppendLog(p))
+	}
+
+	cache, err := memorystorecache.NewCache(*ttl, *gc, opts...)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	conn, err := cache.Open(*dataDir)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	srv := resp.NewServer(conn)
+	var mc *memcache.Server
+	if *memcacheAddr != "" {
+		mc = memcache.NewServer(conn)
+	}
+	var hs *http.Server
+	if *httpAddr != "" {
+		hs = &http.Server{Addr: *httpAddr, Handler: httpapi.NewHandler(conn)}
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srv.Close()
+	}()
+
+	if mc != nil {
+		go func() {
+			log.Printf("memcached protocol listening on %s", *memcacheAddr)
+			if err := mc.ListenAndServe(*memcacheAddr); err != memcache.ErrServerClosed {
+				conn.Close()
+				fatalf("%v", err)
+			}
+		}()
+	}
+
+	if hs != nil {
+		go func() {
+			log.Printf("HTTP API listening on %s", *httpAddr)
+			if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+				conn.Close()
+				fatalf("%v", err)
+			}
+		}()
+	}
+
+	log.Printf("redis protocol listening on %s", *addr)
+	if err := srv.ListenAndServe(*addr); err != resp.ErrServerClosed {
+		conn.Close()
+		fatalf("%v", err)
+	}
+	if mc != nil {
+		mc.Close()
+	}
+	if hs != nil {
+		hs.Close()
+	}
+	if err := conn.Close(); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "memorystore-server: "+format+"\n", args...)
+	os.Exit(1)
+}
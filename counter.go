@@ -0,0 +1,99 @@
+package memorystorecache
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// KeepTTL can be passed as the TTL of an increment to keep the key's expiry
+// keys created by the increment get the default cache TTL
+const KeepTTL time.Duration = -1
+
+var (
+	// ErrNotNumber is returned when incrementing a value that is not a number
+	ErrNotNumber = errors.New("Value is not a number")
+	// ErrOverflow is returned when an increment would overflow
+	ErrOverflow = errors.New("Increment would overflow")
+)
+
+// Incr atomically adds delta to a key holding a decimal integer, returning the new value
+// a missing key starts at 0, ttl sets the key's TTL or is KeepTTL to keep its expiry
+func (c *Conn) Incr(k []byte, delta int64, ttl time.Duration) (int64, error) {
+	var n int64
+	_, err := c.updateCounter(string(k), ttl, func(cur []byte) ([]byte, error) {
+		if cur != nil {
+			var err error
+			if n, err = strconv.ParseInt(string(cur), 10, 64); err != nil {
+				return nil, ErrNotNumber
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, ErrOverflow
+		}
+		n += delta
+		return []byte(strconv.FormatInt(n, 10)), nil
+	})
+	return n, err
+}
+
+// Decr atomically subtracts delta from a key holding a decimal integer, returning the new value
+// it behaves as Incr otherwise
+func (c *Conn) Decr(k []byte, delta int64, ttl time.Duration) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, ErrOverflow
+	}
+	return c.Incr(k, -delta, ttl)
+}
+
+// IncrFloat atomically adds delta to a key holding a decimal number, returning the new value
+// it behaves as Incr otherwise
+func (c *Conn) IncrFloat(k []byte, delta float64, ttl time.Duration) (float64, error) {
+	var f float64
+	_, err := c.updateCounter(string(k), ttl, func(cur []byte) ([]byte, error) {
+		if cur != nil {
+			var err error
+			if f, err = strconv.ParseFloat(string(cur), 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, ErrNotNumber
+			}
+		}
+		f += delta
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, ErrOverflow
+		}
+		return []byte(strconv.FormatFloat(f, 'f', -1, 64)), nil
+	})
+	return f, err
+}
+
+// updateCounter replaces a key's value with fn's result under the shard lock
+// fn is passed nil for a missing key
+func (c *Conn) updateCounter(key string, ttl time.Duration, fn func(cur []byte) ([]byte, error)) (cacheElement, error) {
+	if ttl < 0 && ttl != KeepTTL {
+		return cacheElement{}, errors.New("TTL must not be negative")
+	}
+	return c.updateElement(key, func(cur cacheElement, exists bool, t time.Time) (cacheElement, error) {
+		var v []byte
+		if exists {
+			v = cur.dat
+		}
+		next, err := fn(v)
+		if err != nil {
+			return cacheElement{}, err
+		}
+
+		if exists && ttl == KeepTTL {
+			// keep the expiry and stale times along with the TTLs they came from
+			cur.dat = next
+			cur.fill = false
+			return cur, nil
+		}
+		if ttl == KeepTTL {
+			ttl = c.TTL
+		}
+		el := newElement(next, t, 0, ttl)
+		el.flags = cur.flags
+		return el, nil
+	})
+}
@@ -0,0 +1,135 @@
+package memorystorecache
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncr(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("counter")
+	n, err := conn.Incr(key, 5, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = conn.Decr(key, 7, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-2"), b)
+
+	assert.Nil(t, conn.Write([]byte("text"), []byte("abc")))
+	_, err = conn.Incr([]byte("text"), 1, time.Minute)
+	assert.Equal(t, ErrNotNumber, err)
+
+	assert.Nil(t, conn.Write([]byte("max"), []byte(strconv.FormatInt(math.MaxInt64, 10))))
+	_, err = conn.Incr([]byte("max"), 1, time.Minute)
+	assert.Equal(t, ErrOverflow, err)
+	_, err = conn.Decr(key, math.MinInt64, time.Minute)
+	assert.Equal(t, ErrOverflow, err)
+}
+
+func TestIncrTTL(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Hour, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// a new key gets the default TTL with KeepTTL
+	key := []byte("counter")
+	_, err = conn.Incr(key, 1, KeepTTL)
+	assert.Nil(t, err)
+	ttl, err := conn.RemainingTTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, ttl)
+
+	// KeepTTL keeps the expiry, a TTL resets it
+	_, err = conn.Incr(key, 1, time.Minute)
+	assert.Nil(t, err)
+	clk.Advance(10 * time.Second)
+	_, err = conn.Incr(key, 1, KeepTTL)
+	assert.Nil(t, err)
+	ttl, err = conn.RemainingTTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Second, ttl)
+
+	// an expired counter starts over
+	clk.Advance(time.Minute)
+	n, err := conn.Incr(key, 1, KeepTTL)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = conn.Incr(key, 1, -2)
+	assert.NotNil(t, err)
+}
+
+func TestIncrConcurrent(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				_, err := conn.Incr([]byte("counter"), 1, KeepTTL)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := conn.Read([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), b)
+}
+
+func TestIncrFloat(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("float")
+	f, err := conn.IncrFloat(key, 1.5, KeepTTL)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, f)
+	f, err = conn.IncrFloat(key, 0.25, KeepTTL)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.75, f)
+
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1.75"), b)
+
+	// integers are valid floats
+	_, err = conn.Incr([]byte("int"), 2, KeepTTL)
+	assert.Nil(t, err)
+	f, err = conn.IncrFloat([]byte("int"), 0.5, KeepTTL)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, f)
+
+	_, err = conn.IncrFloat(key, math.Inf(1), KeepTTL)
+	assert.Equal(t, ErrOverflow, err)
+	assert.Nil(t, conn.Write([]byte("text"), []byte("abc")))
+	_, err = conn.IncrFloat([]byte("text"), 1, KeepTTL)
+	assert.Equal(t, ErrNotNumber, err)
+}
@@ -0,0 +1,129 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// EvictReason is why an entry left the cache
+type EvictReason int
+
+const (
+	// EvictExpiredOnRead is an expired entry found by a read and removed by the next write or sweep of its shard,
+	// or found and removed by Delete
+	EvictExpiredOnRead EvictReason = iota
+	// EvictExpiredBySweep is an expired entry removed by garbage collection
+	EvictExpiredBySweep
+	// EvictCapacity is an entry evicted to keep the cache within WithMaxBytes or WithMaxEntries
+	EvictCapacity
+	// EvictDeleted is an entry removed by Delete, CompareAndDelete, Flush, FlushNamespace or InvalidateTag
+	EvictDeleted
+	// EvictOverwritten is an entry replaced by a later write of its key
+	EvictOverwritten
+	// EvictClosed is an entry released by Close
+	EvictClosed
+)
+
+// defaultEvictQueueSize is how many evictions can wait for the OnEvict callback before they are dropped
+const defaultEvictQueueSize = 1024
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpiredOnRead:
+		return "expired-on-read"
+	case EvictExpiredBySweep:
+		return "expired-by-sweep"
+	case EvictCapacity:
+		return "capacity"
+	case EvictDeleted:
+		return "delete"
+	case EvictOverwritten:
+		return "overwrite"
+	case EvictClosed:
+		return "close"
+	}
+	return "unknown"
+}
+
+// OnEvict sets a callback for entries leaving connections opened after it is set
+// the callback runs on its own goroutine, one entry at a time, so it may use the connection
+// entries are queued for it without blocking the cache, and dropped when the queue is full;
+// ConnStats.EvictCallbacksDropped counts them
+// the reason is the operation that removed an entry, so an expired entry that is overwritten
+// before it is found is reported as overwritten
+// Close waits for every entry it releases to be delivered
+func (c *Cache) OnEvict(fn func(key, value []byte, reason EvictReason)) {
+	c.onEvict = fn
+}
+
+// WithEvictQueueSize sets how many evictions can wait for the OnEvict callback, 1024 by default
+func WithEvictQueueSize(n int) Option {
+	return func(c *Cache) error {
+		if n <= 0 {
+			return errors.New("evict queue size must be positive")
+		}
+		c.evictQueueSize = n
+		return nil
+	}
+}
+
+// eviction is an entry queued for the OnEvict callback
+type eviction struct {
+	key    string
+	value  []byte
+	reason EvictReason
+}
+
+// evictQueue delivers evictions to the OnEvict callback outside the shard locks
+type evictQueue struct {
+	dropped uint64
+
+	fn   func(key, value []byte, reason EvictReason)
+	ch   chan eviction
+	done chan struct{}
+}
+
+func newEvictQueue(fn func(key, value []byte, reason EvictReason), size int) *evictQueue {
+	if size == 0 {
+		size = defaultEvictQueueSize
+	}
+	q := &evictQueue{
+		fn:   fn,
+		ch:   make(chan eviction, size),
+		done: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *evictQueue) run() {
+	defer close(q.done)
+	for ev := range q.ch {
+		q.fn([]byte(ev.key), ev.value, ev.reason)
+	}
+}
+
+// push queues an eviction without blocking, dropping it if the queue is full
+func (q *evictQueue) push(ev eviction) {
+	select {
+	case q.ch <- ev:
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+	}
+}
+
+// close delivers the remaining evictions, waiting for the callback to finish them
+func (q *evictQueue) close(last []eviction) {
+	for _, ev := range last {
+		q.ch <- ev
+	}
+	close(q.ch)
+	<-q.done
+}
+
+// notifyLocked queues an entry leaving the shard, the caller must hold the write lock
+func (s *shard) notifyLocked(key string, el cacheElement, reason EvictReason) {
+	if s.evictions != nil {
+		s.evictions.push(eviction{key: key, value: el.dat, reason: reason})
+	}
+}
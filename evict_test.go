@@ -0,0 +1,111 @@
+package memorystorecache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// evictRecorder collects the evictions passed to an OnEvict callback
+type evictRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *evictRecorder) record(key, value []byte, reason EvictReason) {
+	r.mu.Lock()
+	r.events = append(r.events, string(key)+"="+string(value)+" "+reason.String())
+	r.mu.Unlock()
+}
+
+func TestOnEvict(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithShards(1), WithMaxEntries(3), WithClock(clk))
+	assert.Nil(t, err)
+	r := &evictRecorder{}
+	c.OnEvict(r.record)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+
+	assert.Nil(t, conn.Write([]byte("a"), []byte("1")))
+	assert.Nil(t, conn.Write([]byte("a"), []byte("2")))
+	assert.Nil(t, conn.Delete([]byte("a")))
+
+	assert.Nil(t, conn.WriteTTL([]byte("b"), []byte("1"), time.Second))
+	assert.Nil(t, conn.WriteTTL([]byte("c"), []byte("1"), 2*time.Second))
+	clk.Advance(time.Second)
+	_, err = conn.Read([]byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, conn.Write([]byte("d"), []byte("1")))
+	clk.Advance(time.Second)
+	conn.sweep()
+
+	// over WithMaxEntries the least recently used key is evicted
+	assert.Nil(t, conn.Write([]byte("e"), []byte("1")))
+	assert.Nil(t, conn.Write([]byte("f"), []byte("1")))
+	assert.Nil(t, conn.Write([]byte("g"), []byte("1")))
+
+	// Close delivers everything before returning
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, []string{
+		"a=1 overwrite",
+		"a=2 delete",
+		"b=1 expired-on-read",
+		"c=1 expired-by-sweep",
+		"d=1 capacity",
+	}, r.events[:5])
+	assert.ElementsMatch(t, []string{"e=1 close", "f=1 close", "g=1 close"}, r.events[5:])
+}
+
+func TestOnEvictDeleteExpired(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk))
+	assert.Nil(t, err)
+	r := &evictRecorder{}
+	c.OnEvict(r.record)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	sub := conn.Subscribe(nil)
+	defer sub.Close()
+
+	// deleting a key that has already expired reports it as expired, not deleted
+	assert.Nil(t, conn.WriteTTL([]byte("a"), []byte("1"), time.Second))
+	clk.Advance(time.Second)
+	assert.Equal(t, ErrKeyNotFound, conn.Delete([]byte("a")))
+	assert.Equal(t, uint64(0), conn.TypedStats().Deletes)
+
+	events := drain(sub)
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventExpired, events[1].Type)
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, []string{"a=1 expired-on-read"}, r.events)
+}
+
+func TestOnEvictDropped(t *testing.T) {
+	c, err := NewCache(time.Minute, 0, WithEvictQueueSize(1))
+	assert.Nil(t, err)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	delivered := 0
+	c.OnEvict(func(key, value []byte, reason EvictReason) {
+		<-release
+		mu.Lock()
+		delivered++
+		mu.Unlock()
+	})
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+
+	// the callback blocks, so the queue fills without blocking writers
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, conn.Write([]byte("key"), []byte{byte(i)}))
+	}
+	dropped := conn.TypedStats().EvictCallbacksDropped
+	assert.True(t, dropped >= 7)
+
+	close(release)
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, 9-int(dropped)+1, delivered)
+}
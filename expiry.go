@@ -0,0 +1,91 @@
+package memorystorecache
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+)
+
+// defaultSweepLimit is how many expiries a shard processes per sweep when WithSweepLimit is not given
+const defaultSweepLimit = 4096
+
+// WithSweepLimit bounds how many expired keys each shard removes per garbage collection sweep
+// keys left over are removed by later sweeps
+func WithSweepLimit(n int) Option {
+	return func(c *Cache) error {
+		if n <= 0 {
+			return errors.New("sweep limit must be positive")
+		}
+		c.sweepLimit = n
+		return nil
+	}
+}
+
+// expiryItem schedules a key to be checked for expiry
+type expiryItem struct {
+	at  int64 // unix nanoseconds
+	key string
+}
+
+// expiryHeap is a min-heap of keys ordered by expiry
+// items are not removed when their key is deleted or rewritten,
+// instead an item is ignored when it no longer matches its key's expiry
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].at < h[j].at }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryItem)) }
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	it := old[n]
+	old[n] = expiryItem{} // release the key for garbage collection
+	*h = old[:n]
+	return it
+}
+
+// trackExpiry schedules a key's expiry, the caller must hold the write lock
+func (s *shard) trackExpiry(key string, el cacheElement) {
+	if el.expiresAt.IsZero() {
+		return
+	}
+	heap.Push(&s.expiries, expiryItem{at: el.expiresAt.UnixNano(), key: key})
+
+	// rebuild once stale items outnumber live keys, keeping the heap proportional to the shard
+	if len(s.expiries) > 2*len(s.dat)+64 {
+		s.rebuildExpiries()
+	}
+}
+
+// rebuildExpiries rebuilds the heap from the shard's keys, dropping stale items
+// the caller must hold the write lock
+func (s *shard) rebuildExpiries() {
+	h := make(expiryHeap, 0, len(s.dat))
+	for k, el := range s.dat {
+		if !el.expiresAt.IsZero() {
+			h = append(h, expiryItem{at: el.expiresAt.UnixNano(), key: k})
+		}
+	}
+	heap.Init(&h)
+	s.expiries = h
+}
+
+// expireLocked removes keys that have expired at t, checking at most limit heap items
+// it returns how many keys it removed, the caller must hold the write lock
+func (s *shard) expireLocked(t time.Time, limit int) uint64 {
+	now := t.UnixNano()
+	var removed uint64
+	for i := 0; i < limit && len(s.expiries) > 0 && s.expiries[0].at <= now; i++ {
+		it := heap.Pop(&s.expiries).(expiryItem)
+		el, exists := s.dat[it.key]
+		// skip items for keys that were deleted or given a new expiry
+		if !exists || el.expiresAt.IsZero() || el.expiresAt.UnixNano() != it.at {
+			continue
+		}
+		s.removeLocked(it.key, EvictExpiredBySweep)
+		removed++
+	}
+	return removed
+}
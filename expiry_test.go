@@ -0,0 +1,91 @@
+package memorystorecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpireLocked(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithShards(1), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.WriteTTL([]byte("a"), []byte{1}, time.Second))
+	assert.Nil(t, conn.WriteTTL([]byte("b"), []byte{1}, time.Second))
+	assert.Nil(t, conn.WriteTTL([]byte("c"), []byte{1}, time.Second))
+	assert.Nil(t, conn.WriteTTL([]byte("forever"), []byte{1}, 0))
+	// b is given a later expiry and c is deleted, leaving stale heap items
+	assert.Nil(t, conn.TouchTTL([]byte("b"), time.Hour))
+	assert.Nil(t, conn.Delete([]byte("c")))
+
+	s := &conn.shards[0]
+	assert.Len(t, s.expiries, 4)
+
+	clk.Advance(time.Second)
+	s.mu.Lock()
+	removed := s.expireLocked(conn.now(), 10)
+	s.mu.Unlock()
+
+	assert.Equal(t, uint64(1), removed)
+	assert.False(t, conn.Exists([]byte("a")))
+	assert.True(t, conn.Exists([]byte("b")))
+	assert.True(t, conn.Exists([]byte("forever")))
+	// only b's current expiry is left
+	assert.Len(t, s.expiries, 1)
+}
+
+func TestExpireLockedLimit(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, 0, WithShards(1), WithClock(clk), WithSweepLimit(10))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte{1}))
+	}
+	clk.Advance(time.Second)
+
+	// each sweep does a bounded amount of work
+	conn.sweep()
+	assert.Equal(t, uint64(15), conn.keyCount())
+	conn.sweep()
+	conn.sweep()
+	assert.Equal(t, uint64(0), conn.keyCount())
+
+	_, err = NewCache(time.Second, 0, WithSweepLimit(0))
+	assert.EqualError(t, err, "sweep limit must be positive")
+}
+
+func TestExpiryHeapRebuild(t *testing.T) {
+	c, err := NewCache(time.Minute, 0, WithShards(1))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// rewriting a key leaves a stale item behind each time
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, conn.Write([]byte("key"), []byte{1}))
+	}
+	assert.True(t, len(conn.shards[0].expiries) <= 2+64)
+}
+
+func BenchmarkSweepNothingExpired(b *testing.B) {
+	c, _ := NewCache(time.Hour, 0)
+	conn, _ := c.Open("")
+	defer conn.Close()
+
+	writeData(conn, 1000000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.sweep()
+	}
+}
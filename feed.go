@@ -0,0 +1,265 @@
+package memorystorecache
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSlowConsumer is returned by Subscription.Err after a Disconnect subscription fell behind
+var ErrSlowConsumer = errors.New("Subscriber too slow")
+
+// EventType is the kind of change an Event reports
+type EventType int
+
+const (
+	// EventSet is a key written, including by counters; Touch and TouchTTL do not send events
+	EventSet EventType = iota
+	// EventDelete is a key removed by Delete, CompareAndDelete, Flush, FlushNamespace or InvalidateTag
+	EventDelete
+	// EventExpired is an expired key removed after a read or Delete found it, or by a sweep
+	EventExpired
+	// EventEvicted is a key evicted to keep the cache within WithMaxBytes or WithMaxEntries
+	EventEvicted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "del"
+	case EventExpired:
+		return "expired"
+	case EventEvicted:
+		return "evicted"
+	}
+	return "unknown"
+}
+
+// Event is a change to a key
+type Event struct {
+	// Seq numbers a subscription's events from 1, a gap means events were dropped
+	Seq   uint64
+	Type  EventType
+	Key   []byte
+	Value []byte // the value written, for EventSet only
+}
+
+// SlowPolicy decides what happens when a subscriber's buffer is full
+type SlowPolicy int
+
+const (
+	// SlowDrop drops events for the subscriber, leaving a gap in its sequence numbers
+	SlowDrop SlowPolicy = iota
+	// SlowBlock blocks writers until the subscriber catches up
+	// the subscriber must not write to the connection from the goroutine reading events
+	SlowBlock
+	// SlowDisconnect closes the subscription, and Err returns ErrSlowConsumer
+	SlowDisconnect
+)
+
+// defaultSubscribeBuffer is how many events a subscription buffers by default
+const defaultSubscribeBuffer = 256
+
+// SubscribeOption configures a Subscription
+type SubscribeOption func(*Subscription)
+
+// SubscribeBuffer sets how many events a subscription buffers, 256 by default
+func SubscribeBuffer(n int) SubscribeOption {
+	return func(s *Subscription) {
+		if n > 0 {
+			s.size = n
+		}
+	}
+}
+
+// SubscribeSlowPolicy sets what happens when the subscriber falls behind, SlowDrop by default
+func SubscribeSlowPolicy(p SlowPolicy) SubscribeOption {
+	return func(s *Subscription) {
+		s.policy = p
+	}
+}
+
+// Subscription receives events for keys starting with a prefix
+type Subscription struct {
+	dropped uint64
+
+	// C receives events in the order they happened to each key, and is closed when the subscription ends
+	C <-chan Event
+
+	prefix string
+	size   int
+	policy SlowPolicy
+	feed   *changeFeed
+
+	// mu orders sequence numbers with sends and guards ch, seq and err
+	mu     sync.Mutex
+	ch     chan Event
+	seq    uint64
+	closed bool
+	err    error
+	// done is closed by Close to release writers blocked by SlowBlock
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe returns a subscription to changes of keys starting with prefix
+// events are sent while the key's shard is locked, so each key's events arrive in order
+// and a SlowBlock subscriber holds up writers to the shard until it reads
+func (c *Conn) Subscribe(prefix []byte, opts ...SubscribeOption) *Subscription {
+	s := &Subscription{
+		prefix: string(prefix),
+		size:   defaultSubscribeBuffer,
+		feed:   c.feed,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ch = make(chan Event, s.size)
+	s.C = s.ch
+	c.feed.add(s)
+	return s
+}
+
+// Close ends the subscription and closes C
+func (s *Subscription) Close() {
+	s.end(nil)
+}
+
+// Err returns ErrSlowConsumer if the subscription was closed for falling behind, nil otherwise
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Dropped returns how many events were dropped for the subscriber
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// end removes the subscription from its feed and closes C, recording err
+func (s *Subscription) end(err error) {
+	// release a blocked writer first, it holds mu
+	s.closeOnce.Do(func() { close(s.done) })
+	s.feed.remove(s)
+	s.mu.Lock()
+	s.closeLocked(err)
+	s.mu.Unlock()
+}
+
+// closeLocked closes C once, the caller must hold mu
+func (s *Subscription) closeLocked(err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.ch)
+}
+
+// send delivers an event according to the subscription's policy
+func (s *Subscription) send(typ EventType, key string, value []byte) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.seq++
+	ev := Event{Seq: s.seq, Type: typ, Key: []byte(key), Value: value}
+	select {
+	case s.ch <- ev:
+		s.mu.Unlock()
+		return
+	default:
+	}
+
+	switch s.policy {
+	case SlowBlock:
+		select {
+		case s.ch <- ev:
+		case <-s.done:
+		}
+		s.mu.Unlock()
+	case SlowDisconnect:
+		s.closeLocked(ErrSlowConsumer)
+		s.mu.Unlock()
+		s.end(ErrSlowConsumer)
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		s.mu.Unlock()
+	}
+}
+
+// changeFeed fans a connection's changes out to its subscriptions
+type changeFeed struct {
+	// subs holds a []*Subscription, replaced as a whole under mu when one is added or removed
+	subs   atomic.Value
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *changeFeed) add(s *Subscription) {
+	f.mu.Lock()
+	if f.closed {
+		// the connection is closed, so the subscription ends straight away
+		f.mu.Unlock()
+		s.Close()
+		return
+	}
+	old, _ := f.subs.Load().([]*Subscription)
+	subs := make([]*Subscription, len(old), len(old)+1)
+	copy(subs, old)
+	f.subs.Store(append(subs, s))
+	f.mu.Unlock()
+}
+
+func (f *changeFeed) remove(s *Subscription) {
+	f.mu.Lock()
+	old, _ := f.subs.Load().([]*Subscription)
+	subs := make([]*Subscription, 0, len(old))
+	for _, o := range old {
+		if o != s {
+			subs = append(subs, o)
+		}
+	}
+	f.subs.Store(subs)
+	f.mu.Unlock()
+}
+
+// publish sends an event to every subscription whose prefix matches the key
+func (f *changeFeed) publish(typ EventType, key string, value []byte) {
+	subs, _ := f.subs.Load().([]*Subscription)
+	for _, s := range subs {
+		if strings.HasPrefix(key, s.prefix) {
+			s.send(typ, key, value)
+		}
+	}
+}
+
+// close ends every subscription, and any made later
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	subs, _ := f.subs.Load().([]*Subscription)
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+// eventFor maps why a key was removed to the event subscribers see, false for removals they do not see
+func eventFor(reason EvictReason) (EventType, bool) {
+	switch reason {
+	case EvictExpiredOnRead, EvictExpiredBySweep:
+		return EventExpired, true
+	case EvictCapacity:
+		return EventEvicted, true
+	case EvictDeleted:
+		return EventDelete, true
+	}
+	return 0, false
+}
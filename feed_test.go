@@ -0,0 +1,168 @@
+package memorystorecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// drain reads the events buffered for a subscription
+func drain(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithShards(1), WithMaxEntries(2), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	sub := conn.Subscribe([]byte("user:"))
+	defer sub.Close()
+	posts := conn.Subscribe([]byte("post:"))
+	defer posts.Close()
+
+	assert.Nil(t, conn.Write([]byte("user:1"), []byte("a")))
+	assert.Nil(t, conn.Write([]byte("post:1"), []byte("b")))
+	assert.Nil(t, conn.Delete([]byte("user:1")))
+	assert.Nil(t, conn.WriteTTL([]byte("user:2"), []byte("c"), time.Second))
+	clk.Advance(time.Second)
+	conn.sweep()
+	assert.Nil(t, conn.Write([]byte("user:3"), []byte("d")))
+	assert.Nil(t, conn.Write([]byte("user:4"), []byte("e")))
+
+	var got []string
+	for _, ev := range drain(sub) {
+		got = append(got, fmt.Sprintf("%d %s %s %s", ev.Seq, ev.Type, ev.Key, ev.Value))
+	}
+	assert.Equal(t, []string{
+		"1 set user:1 a",
+		"2 del user:1 ",
+		"3 set user:2 c",
+		"4 expired user:2 ",
+		"5 set user:3 d",
+		"6 set user:4 e",
+	}, got)
+
+	// over WithMaxEntries the least recently used key is evicted
+	events := drain(posts)
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventEvicted, events[1].Type)
+	assert.Equal(t, uint64(2), events[1].Seq)
+}
+
+func TestSubscribeDrop(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	sub := conn.Subscribe(nil, SubscribeBuffer(2))
+	defer sub.Close()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, conn.Write([]byte("key"), []byte{byte(i)}))
+	}
+
+	// dropped events leave a gap before the next one read
+	events := drain(sub)
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint64(3), sub.Dropped())
+	assert.Nil(t, conn.Write([]byte("key"), []byte{5}))
+	ev := <-sub.C
+	assert.Equal(t, uint64(6), ev.Seq)
+	assert.Nil(t, sub.Err())
+}
+
+func TestSubscribeBlock(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	sub := conn.Subscribe(nil, SubscribeBuffer(1), SubscribeSlowPolicy(SlowBlock))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			conn.Write([]byte("key"), []byte{byte(i)})
+		}
+	}()
+
+	// every event arrives, in order, while the writer waits on the subscriber
+	for i := 0; i < 10; i++ {
+		ev := <-sub.C
+		assert.Equal(t, uint64(i+1), ev.Seq)
+		assert.Equal(t, []byte{byte(i)}, ev.Value)
+	}
+	<-done
+	assert.Equal(t, uint64(0), sub.Dropped())
+
+	// closing releases a blocked writer
+	assert.Nil(t, conn.Write([]byte("key"), []byte{1}))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sub.Close()
+	}()
+	assert.Nil(t, conn.Write([]byte("key"), []byte{2}))
+}
+
+func TestSubscribeDisconnect(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	sub := conn.Subscribe(nil, SubscribeBuffer(2), SubscribeSlowPolicy(SlowDisconnect))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, conn.Write([]byte("key"), []byte{byte(i)}))
+	}
+
+	// the buffered events are still delivered before C is closed
+	var n int
+	for range sub.C {
+		n++
+	}
+	assert.Equal(t, 2, n)
+	assert.Equal(t, ErrSlowConsumer, sub.Err())
+}
+
+func TestSubscribeClose(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+
+	sub := conn.Subscribe(nil)
+	sub.Close()
+	sub.Close()
+	_, ok := <-sub.C
+	assert.False(t, ok)
+	assert.Nil(t, conn.Write([]byte("key"), []byte{1}))
+
+	// closing the connection ends its subscriptions
+	sub = conn.Subscribe(nil)
+	assert.Nil(t, conn.Close())
+	_, ok = <-sub.C
+	assert.False(t, ok)
+	sub = conn.Subscribe(nil)
+	_, ok = <-sub.C
+	assert.False(t, ok)
+}
@@ -0,0 +1,98 @@
+package memorystorecache
+
+// matchGlob reports whether s matches a Redis style glob pattern
+// supporting *, ?, [abc], [^abc], [a-z] and backslash escapes
+//
+// on a mismatch after a * it backtracks to that * only, letting it take one more byte,
+// so matching takes O(len(pattern) * len(s)) however many stars the pattern has
+func matchGlob(pattern, s []byte) bool {
+	p, i := 0, 0
+	// starP is where the pattern resumes after the last *, and starI where that * stopped in s
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			starP, starI = p, i
+			continue
+		}
+		if p < len(pattern) {
+			if ok, n := matchByte(pattern[p:], s[i]); ok {
+				p += n
+				i++
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starI++
+		p, i = starP, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchByte matches b against the single byte pattern (not *) at the start of pattern
+// it returns how many bytes of pattern it used
+func matchByte(pattern []byte, b byte) (bool, int) {
+	switch pattern[0] {
+	case '?':
+		return true, 1
+	case '[':
+		ok, rest := matchClass(pattern[1:], b)
+		return ok, len(pattern) - len(rest)
+	case '\\':
+		if len(pattern) > 1 {
+			return pattern[1] == b, 2
+		}
+	}
+	return pattern[0] == b, 1
+}
+
+// matchClass matches b against the character class at the start of pattern, just after the [
+// it returns the pattern following the class
+func matchClass(pattern []byte, b byte) (bool, []byte) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	var matched bool
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == b
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (b >= lo && b <= hi)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == b
+			pattern = pattern[1:]
+		}
+	}
+	// an unterminated class runs to the end of the pattern
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != negate, pattern
+}
+
+// globPrefix returns the part of pattern before its first special character
+func globPrefix(pattern []byte) []byte {
+	for i, b := range pattern {
+		switch b {
+		case '*', '?', '[', '\\':
+			return pattern[:i]
+		}
+	}
+	return pattern
+}
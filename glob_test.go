@@ -0,0 +1,57 @@
+package memorystorecache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "episode:1", false},
+		{"*:1", "user:1", true},
+		{"user:?", "user:12", false},
+		{"user:??", "user:12", true},
+		{"user:[0-9]", "user:7", true},
+		{"user:[^0-9]", "user:7", false},
+		{"user:[ab]", "user:b", true},
+		{"user:\\*", "user:*", true},
+		{"user:\\*", "user:1", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"a**", "a", true},
+		{"*a?c", "abcabc", true},
+		{"*[0-9]x", "ab1y2x", true},
+		{"*\\?", "ab?", true},
+		{"*\\?", "abc", false},
+		{"a\\", "a\\", true},
+		{"*b", "abc", false},
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchGlob([]byte(tt.pattern), []byte(tt.s)), tt.pattern+" "+tt.s)
+	}
+}
+
+func TestMatchGlobPathological(t *testing.T) {
+	// each * used to retry every split of the rest of s, taking exponential time
+	pattern := []byte(strings.Repeat("a*", 30) + "b")
+	s := []byte(strings.Repeat("a", 100))
+	start := time.Now()
+	assert.False(t, matchGlob(pattern, s))
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "took %s", time.Since(start))
+}
+
+func TestGlobPrefix(t *testing.T) {
+	assert.Equal(t, []byte("user:"), globPrefix([]byte("user:*")))
+	assert.Equal(t, []byte("user"), globPrefix([]byte("user")))
+	assert.Equal(t, []byte{}, globPrefix([]byte("[a]*")))
+}
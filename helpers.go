@@ -1,21 +1,34 @@
 package memorystorecache
 
-import (
-	"strings"
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
 )
 
-func keyToShard(key string) int {
-	i := int(strings.ToLower(key)[0])
-	// handle numeric chars
-	if i >= 48 && i <= 57 {
-		return i - 48
+// keyHash hashes a key with 64-bit FNV-1a
+// it is inlined here to avoid allocating a hash.Hash64 on every call
+func keyHash(key string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
 	}
+	return h
+}
 
-	// handle alpha chars
-	if i >= 97 && i <= 122 {
-		return i - 97 + 10 // add 10 for number of numeric keys
-	}
+// keyToShard maps a key to a shard index
+// mask must be the shard count minus one, where the shard count is a power of two
+func keyToShard(key string, mask uint64) int {
+	return int(keyHash(key) & mask)
+}
 
-	// if we're not in the numeric or alpha char range, we'll stick it in the z bucket
-	return numBuckets - 1
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
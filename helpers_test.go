@@ -1,26 +1,51 @@
 package memorystorecache
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestKeyToShard(t *testing.T) {
+	data := []string{"aasdf", "basdf", "zasfd", "1asdf", "0basfd", "9basfd", "'basfd", ""}
+
+	for _, key := range data {
+		shard := keyToShard(key, 63)
+		assert.True(t, shard >= 0 && shard < 64)
+		// the same key always lands in the same shard
+		assert.Equal(t, shard, keyToShard(key, 63))
+	}
+}
+
+func TestKeyToShardPrefixedKeys(t *testing.T) {
+	used := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		used[keyToShard(fmt.Sprintf("episode:%d", i), 15)]++
+	}
+
+	// keys sharing a prefix should still use every shard
+	assert.Len(t, used, 16)
+	for _, n := range used {
+		assert.True(t, n > 20, "shard is underused: %d keys", n)
+	}
+}
+
+func TestIsPowerOfTwo(t *testing.T) {
 	data := []struct {
-		key   string
-		shard int
+		n   int
+		out bool
 	}{
-		{"aasdf", 10},
-		{"basdf", 11},
-		{"zasfd", 35},
-		{"1asdf", 1},
-		{"0basfd", 0},
-		{"9basfd", 9},
-		{"'basfd", 35},
+		{0, false},
+		{1, true},
+		{2, true},
+		{3, false},
+		{64, true},
+		{100, false},
+		{-4, false},
 	}
 
 	for _, item := range data {
-		assert.Equal(t, item.shard, keyToShard(item.key))
+		assert.Equal(t, item.out, isPowerOfTwo(item.n))
 	}
 }
@@ -0,0 +1,101 @@
+package memorystorecache
+
+import (
+	"strings"
+	"time"
+)
+
+// Iterate calls fn for every unexpired key starting with prefix, until fn returns false
+// shards are copied one at a time and fn is called without holding any lock, so fn may use the connection,
+// but keys written to other shards during the walk may or may not be seen
+// a key that never expires is passed the zero time
+func (c *Conn) Iterate(prefix []byte, fn func(key, value []byte, expiresAt time.Time) bool) {
+	var entries []snapshotEntry
+	for i := range c.shards {
+		entries = c.copyShardPrefix(i, string(prefix), entries[:0])
+		for _, e := range entries {
+			if !fn([]byte(e.key), e.el.dat, e.el.expiresAt) {
+				return
+			}
+		}
+	}
+}
+
+// IterateConsistent calls fn for every unexpired key starting with prefix as of a single point in time,
+// until fn returns false
+// every shard is read locked while the matching keys are copied, which blocks writers for longer than Iterate
+func (c *Conn) IterateConsistent(prefix []byte, fn func(key, value []byte, expiresAt time.Time) bool) {
+	for i := range c.shards {
+		c.shards[i].mu.RLock()
+	}
+	t := c.now()
+	p := string(prefix)
+	var entries []snapshotEntry
+	for i := range c.shards {
+		for key, el := range c.shards[i].dat {
+			if strings.HasPrefix(key, p) && !el.expired(t) {
+				entries = append(entries, snapshotEntry{key: key, el: el})
+			}
+		}
+	}
+	for i := range c.shards {
+		c.shards[i].mu.RUnlock()
+	}
+
+	for _, e := range entries {
+		if !fn([]byte(e.key), e.el.dat, e.el.expiresAt) {
+			return
+		}
+	}
+}
+
+// copyShardPrefix appends the unexpired entries of a shard whose keys start with prefix to entries
+func (c *Conn) copyShardPrefix(idx int, prefix string, entries []snapshotEntry) []snapshotEntry {
+	t := c.now()
+	s := &c.shards[idx]
+
+	s.mu.RLock()
+	for k, el := range s.dat {
+		if strings.HasPrefix(k, prefix) && !el.expired(t) {
+			entries = append(entries, snapshotEntry{key: k, el: el})
+		}
+	}
+	s.mu.RUnlock()
+	return entries
+}
+
+// Scan returns a page of unexpired keys matching a glob pattern, and the cursor for the next page
+// start with a cursor of 0 and call Scan with the returned cursor until it is 0 again
+// match supports *, ?, [abc], [^abc], [a-z] and backslash escapes, a nil pattern matches every key
+//
+// the cursor is a shard index, so each call reads whole shards until it has at least count keys
+// and holds one shard lock at a time; keys present for the whole scan are returned exactly once
+func (c *Conn) Scan(cursor uint64, match []byte, count int) ([][]byte, uint64) {
+	if count < 1 {
+		count = 1
+	}
+	t := c.now()
+	prefix := string(globPrefix(match))
+
+	var keys [][]byte
+	i := cursor
+	for ; i < uint64(len(c.shards)) && len(keys) < count; i++ {
+		s := &c.shards[i]
+		s.mu.RLock()
+		for k, el := range s.dat {
+			if !strings.HasPrefix(k, prefix) || el.expired(t) {
+				continue
+			}
+			if match != nil && !matchGlob(match, []byte(k)) {
+				continue
+			}
+			keys = append(keys, []byte(k))
+		}
+		s.mu.RUnlock()
+	}
+
+	if i >= uint64(len(c.shards)) {
+		return keys, 0
+	}
+	return keys, i
+}
@@ -0,0 +1,131 @@
+package memorystorecache
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterate(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk), WithShards(4))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, conn.Write([]byte(fmt.Sprintf("user:%d", i)), []byte{byte(i)}))
+	}
+	assert.Nil(t, conn.WriteTTL([]byte("user:forever"), []byte{1}, 0))
+	assert.Nil(t, conn.WriteTTL([]byte("user:expiring"), []byte{1}, time.Second))
+	assert.Nil(t, conn.Write([]byte("episode:1"), []byte{1}))
+	clk.Advance(time.Second)
+
+	seen := map[string]time.Time{}
+	conn.Iterate([]byte("user:"), func(key, value []byte, expiresAt time.Time) bool {
+		seen[string(key)] = expiresAt
+		return true
+	})
+	assert.Len(t, seen, 11)
+	assert.True(t, seen["user:forever"].IsZero())
+	assert.True(t, clk.Now().Add(time.Minute-time.Second).Equal(seen["user:0"]))
+	_, ok := seen["user:expiring"]
+	assert.False(t, ok)
+
+	// returning false stops the walk
+	n := 0
+	conn.Iterate(nil, func(key, value []byte, expiresAt time.Time) bool {
+		n++
+		return n < 3
+	})
+	assert.Equal(t, 3, n)
+}
+
+func TestIterateWrites(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte{1}))
+	}
+
+	// no lock is held while fn runs, so it can write to the connection
+	conn.Iterate(nil, func(key, value []byte, expiresAt time.Time) bool {
+		assert.Nil(t, conn.Delete(key))
+		return true
+	})
+	assert.Equal(t, uint64(0), conn.keyCount())
+}
+
+func TestIterateConsistent(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte{1}))
+	}
+
+	// keys written during the walk are not seen
+	n := 0
+	conn.IterateConsistent([]byte("key-"), func(key, value []byte, expiresAt time.Time) bool {
+		assert.Nil(t, conn.Write([]byte("key-new-"+string(key)), []byte{1}))
+		n++
+		return true
+	})
+	assert.Equal(t, 10, n)
+}
+
+func TestScan(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk), WithShards(8))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var want []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		want = append(want, key)
+		assert.Nil(t, conn.Write([]byte(key), []byte{1}))
+	}
+	assert.Nil(t, conn.Write([]byte("episode:1"), []byte{1}))
+	assert.Nil(t, conn.WriteTTL([]byte("user:expiring"), []byte{1}, time.Second))
+	clk.Advance(time.Second)
+
+	var got []string
+	var cursor uint64
+	calls := 0
+	for {
+		var keys [][]byte
+		keys, cursor = conn.Scan(cursor, []byte("user:*"), 10)
+		for _, k := range keys {
+			got = append(got, string(k))
+		}
+		calls++
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	assert.Equal(t, want, got)
+	assert.True(t, calls > 1)
+
+	keys, cursor := conn.Scan(0, nil, 1000)
+	assert.Equal(t, uint64(0), cursor)
+	assert.Len(t, keys, 101)
+
+	keys, _ = conn.Scan(0, []byte("user:[0-1]"), 1000)
+	assert.Len(t, keys, 2)
+}
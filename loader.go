@@ -0,0 +1,172 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLoaderPanicked is returned to callers waiting on a GetOrLoad loader that panicked,
+// the caller running the loader gets the panic
+var ErrLoaderPanicked = errors.New("Loader panicked")
+
+// WithNegativeTTL caches loader errors returned through GetOrLoad for ttl
+// so a failing origin is not called again for every miss
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(c *Cache) error {
+		if ttl < 0 {
+			return errors.New("negative TTL must not be negative")
+		}
+		c.negativeTTL = ttl
+		return nil
+	}
+}
+
+// loadCall is an in flight or completed loader call
+type loadCall struct {
+	wg  sync.WaitGroup
+	val []byte
+	err error
+	// waiters counts callers waiting on another caller's load
+	waiters int
+}
+
+// negativeEntry is a cached loader error
+type negativeEntry struct {
+	err       error
+	expiresAt time.Time
+}
+
+// loadGroup coalesces concurrent loads of the same key into one loader call
+type loadGroup struct {
+	mu         sync.Mutex
+	calls      map[string]*loadCall
+	negatives  map[string]negativeEntry
+	refreshing map[string]struct{}
+}
+
+// do runs fn once for concurrent callers with the same key
+// shared is true for callers that waited on another caller's load
+func (g *loadGroup) do(key string, fn func() ([]byte, error)) (val []byte, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*loadCall{}
+	}
+	if lc, ok := g.calls[key]; ok {
+		lc.waiters++
+		g.mu.Unlock()
+		lc.wg.Wait()
+		return lc.val, lc.err, true
+	}
+	lc := &loadCall{}
+	lc.wg.Add(1)
+	g.calls[key] = lc
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		// waiters must not see a nil value and error if fn panicked,
+		// the panic carries on up this caller's stack once they are released
+		if !returned {
+			lc.val, lc.err = nil, ErrLoaderPanicked
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		lc.wg.Done()
+	}()
+	lc.val, lc.err = fn()
+	returned = true
+	return lc.val, lc.err, false
+}
+
+// waiting returns how many callers are waiting on the in flight load of key
+func (g *loadGroup) waiting(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if lc, ok := g.calls[key]; ok {
+		return lc.waiters
+	}
+	return 0
+}
+
+// negative returns the cached loader error for key, if any
+func (g *loadGroup) negative(key string, t time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ne, ok := g.negatives[key]
+	if !ok {
+		return nil
+	}
+	if !t.Before(ne.expiresAt) {
+		delete(g.negatives, key)
+		return nil
+	}
+	return ne.err
+}
+
+func (g *loadGroup) setNegative(key string, err error, expiresAt time.Time) {
+	g.mu.Lock()
+	if g.negatives == nil {
+		g.negatives = map[string]negativeEntry{}
+	}
+	g.negatives[key] = negativeEntry{err: err, expiresAt: expiresAt}
+	g.mu.Unlock()
+}
+
+// sweepNegatives removes cached loader errors that have expired at t
+func (g *loadGroup) sweepNegatives(t time.Time) {
+	g.mu.Lock()
+	for k, ne := range g.negatives {
+		if !t.Before(ne.expiresAt) {
+			delete(g.negatives, k)
+		}
+	}
+	g.mu.Unlock()
+}
+
+// GetOrLoad reads a key, calling loader and writing its result with ttl on a miss
+// concurrent misses for the same key share a single loader call
+func (c *Conn) GetOrLoad(k []byte, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	if v, err := c.Read(k); err == nil {
+		return v, nil
+	}
+
+	key := string(k)
+	if err := c.loads.negative(key, c.now()); err != nil {
+		atomic.AddUint64(&c.stats.negativeHits, 1)
+		return nil, err
+	}
+
+	v, err, shared := c.loads.do(key, func() ([]byte, error) {
+		// another load may have filled the key since the miss
+		if v, ok := c.peek(key); ok {
+			return v, nil
+		}
+
+		start := c.clock.Now()
+		v, err := loader()
+		delta := c.clock.Now().Sub(start)
+		atomic.AddUint64(&c.stats.loaderCalls, 1)
+		atomic.AddUint64(&c.stats.loaderNanos, uint64(delta))
+		if err != nil {
+			atomic.AddUint64(&c.stats.loaderErrors, 1)
+			if c.negativeTTL > 0 {
+				c.loads.setNegative(key, err, c.now().Add(c.negativeTTL))
+			}
+			return nil, err
+		}
+
+		// a value that cannot be cached is still returned to the caller
+		el := newElement(v, c.now(), 0, ttl)
+		el.delta = delta
+		el.fill = true
+		c.writeElement(key, el)
+		return v, nil
+	})
+	if shared {
+		atomic.AddUint64(&c.stats.loaderWaits, 1)
+	}
+	return v, err
+}
@@ -0,0 +1,182 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	calls := 0
+	loader := func() ([]byte, error) {
+		calls++
+		return []byte{1, 2}, nil
+	}
+
+	// cache miss calls the loader and fills the cache
+	b, err := conn.GetOrLoad(key, time.Minute, loader)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, b)
+	b, err = conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, b)
+
+	// cache hit does not
+	b, err = conn.GetOrLoad(key, time.Minute, loader)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, b)
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetOrLoadCoalesces(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte{1}, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := conn.GetOrLoad([]byte("key"), time.Minute, loader)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte{1}, b)
+		}()
+	}
+
+	// release the load once every other caller is waiting on it
+	waitFor(t, func() bool { return conn.loads.waiting("key") == 9 })
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	s, err := conn.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["LoaderCalls"])
+	assert.Equal(t, uint64(9), s["LoaderCoalesced"])
+}
+
+func TestGetOrLoadPanic(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	release := make(chan struct{})
+	loader := func() ([]byte, error) {
+		<-release
+		panic("origin exploded")
+	}
+
+	// the caller running the loader gets the panic
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		conn.GetOrLoad([]byte("key"), time.Minute, loader)
+	}()
+	waitFor(t, func() bool {
+		conn.loads.mu.Lock()
+		defer conn.loads.mu.Unlock()
+		return conn.loads.calls["key"] != nil
+	})
+
+	// and callers waiting on it get an error instead of a nil value
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := conn.GetOrLoad([]byte("key"), time.Minute, loader)
+			assert.Equal(t, ErrLoaderPanicked, err)
+			assert.Nil(t, b)
+		}()
+	}
+	waitFor(t, func() bool { return conn.loads.waiting("key") == 3 })
+	close(release)
+	wg.Wait()
+	assert.Equal(t, "origin exploded", <-panicked)
+
+	// a later load runs again
+	b, err := conn.GetOrLoad([]byte("key"), time.Minute, func() ([]byte, error) { return []byte{1}, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	calls := 0
+	loader := func() ([]byte, error) {
+		calls++
+		return nil, errors.New("origin down")
+	}
+
+	// errors are not cached without a negative TTL
+	for i := 0; i < 2; i++ {
+		_, err = conn.GetOrLoad([]byte("key"), time.Minute, loader)
+		assert.EqualError(t, err, "origin down")
+	}
+	assert.Equal(t, 2, calls)
+	assert.False(t, conn.Exists([]byte("key")))
+}
+
+func TestGetOrLoadNegativeTTL(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, time.Second, WithNegativeTTL(100*time.Millisecond), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	calls := 0
+	loader := func() ([]byte, error) {
+		calls++
+		return nil, errors.New("origin down")
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = conn.GetOrLoad([]byte("key"), time.Minute, loader)
+		assert.EqualError(t, err, "origin down")
+	}
+	assert.Equal(t, 1, calls)
+
+	// the cached error expires
+	clk.Advance(100 * time.Millisecond)
+	_, err = conn.GetOrLoad([]byte("key"), time.Minute, loader)
+	assert.EqualError(t, err, "origin down")
+	assert.Equal(t, 2, calls)
+
+	s, err := conn.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), s["LoaderErrors"])
+	assert.Equal(t, uint64(1), s["LoaderNegativeHits"])
+
+	_, err = NewCache(time.Second, time.Second, WithNegativeTTL(-time.Second))
+	assert.EqualError(t, err, "negative TTL must not be negative")
+}
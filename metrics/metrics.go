@@ -0,0 +1,194 @@
+// Package metrics exports the stats of a memory store connection to Prometheus
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+// Opts configures a Collector
+type Opts struct {
+	// Namespace and Subsystem prefix every metric name, Subsystem defaults to "memorystore"
+	Namespace string
+	Subsystem string
+	// ConstLabels are added to every metric, e.g. to tell several caches apart
+	ConstLabels prometheus.Labels
+	// Buckets are the latency histogram buckets in seconds, defaulting to ExponentialBuckets(1e-7, 4, 12)
+	Buckets []float64
+}
+
+// Collector is a prometheus.Collector for a memory store connection
+// it also records Read, Write and sweep latencies as the connection's Observer
+type Collector struct {
+	conn *memorystorecache.Conn
+
+	keys          *prometheus.Desc
+	bytes         *prometheus.Desc
+	hits          *prometheus.Desc
+	misses        *prometheus.Desc
+	expiredOnRead *prometheus.Desc
+	writes        *prometheus.Desc
+	deletes       *prometheus.Desc
+	evictions     *prometheus.Desc
+	sweeps        *prometheus.Desc
+	sweepRemoved  *prometheus.Desc
+	loaderCalls   *prometheus.Desc
+	loaderErrors  *prometheus.Desc
+
+	writeBehindQueued   *prometheus.Desc
+	writeBehindFlushed  *prometheus.Desc
+	writeBehindFailures *prometheus.Desc
+
+	readDuration  *prometheus.HistogramVec
+	writeDuration prometheus.Histogram
+	sweepDuration prometheus.Histogram
+}
+
+// NewCollector creates a Collector for conn and sets it as conn's Observer
+func NewCollector(conn *memorystorecache.Conn, opts Opts) *Collector {
+	c := newCollector(conn, opts)
+	conn.SetObserver(c)
+	return c
+}
+
+// Register creates a Collector for conn and registers it with reg
+// conn's Observer is only replaced once registration succeeds
+func Register(reg prometheus.Registerer, conn *memorystorecache.Conn, opts Opts) (*Collector, error) {
+	c := newCollector(conn, opts)
+	if err := reg.Register(c); err != nil {
+		return nil, err
+	}
+	conn.SetObserver(c)
+	return c, nil
+}
+
+func newCollector(conn *memorystorecache.Conn, opts Opts) *Collector {
+	if opts.Subsystem == "" {
+		opts.Subsystem = "memorystore"
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = prometheus.ExponentialBuckets(1e-7, 4, 12)
+	}
+
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, name),
+			help, labels, opts.ConstLabels,
+		)
+	}
+	histogram := func(name, help string) prometheus.HistogramOpts {
+		return prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        name,
+			Help:        help,
+			ConstLabels: opts.ConstLabels,
+			Buckets:     opts.Buckets,
+		}
+	}
+
+	c := &Collector{
+		conn:          conn,
+		keys:          desc("keys", "Number of keys stored, including expired keys not yet removed."),
+		bytes:         desc("bytes", "Bytes of keys and values stored."),
+		hits:          desc("hits_total", "Reads that found an unexpired key."),
+		misses:        desc("misses_total", "Reads that did not find an unexpired key."),
+		expiredOnRead: desc("expired_on_read_total", "Reads that found an expired key."),
+		writes:        desc("writes_total", "Keys written."),
+		deletes:       desc("deletes_total", "Keys deleted."),
+		evictions:     desc("evictions_total", "Keys evicted to stay within the memory budget.", "cause"),
+		sweeps:        desc("sweeps_total", "Garbage collection sweeps run."),
+		sweepRemoved:  desc("sweep_removed_total", "Expired keys removed by garbage collection sweeps."),
+		loaderCalls:   desc("loader_calls_total", "Loader calls made by GetOrLoad."),
+		loaderErrors:  desc("loader_errors_total", "Loader calls made by GetOrLoad that failed."),
+
+		writeBehindQueued:   desc("write_behind_queued", "Changes waiting to be written behind to the store."),
+		writeBehindFlushed:  desc("write_behind_flushed_total", "Changes written behind to the store."),
+		writeBehindFailures: desc("write_behind_failures_total", "Batches the store failed to write."),
+
+		readDuration: prometheus.NewHistogramVec(
+			histogram("read_duration_seconds", "Latency of reads."),
+			[]string{"result"},
+		),
+		writeDuration: prometheus.NewHistogram(histogram("write_duration_seconds", "Latency of writes.")),
+		sweepDuration: prometheus.NewHistogram(histogram("sweep_duration_seconds", "Duration of garbage collection sweeps.")),
+	}
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.keys
+	ch <- c.bytes
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.expiredOnRead
+	ch <- c.writes
+	ch <- c.deletes
+	ch <- c.evictions
+	ch <- c.sweeps
+	ch <- c.sweepRemoved
+	ch <- c.loaderCalls
+	ch <- c.loaderErrors
+	ch <- c.writeBehindQueued
+	ch <- c.writeBehindFlushed
+	ch <- c.writeBehindFailures
+	c.readDuration.Describe(ch)
+	c.writeDuration.Describe(ch)
+	c.sweepDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	st := c.conn.TypedStats()
+
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+
+	gauge(c.keys, float64(st.KeyCount))
+	gauge(c.bytes, float64(st.Bytes))
+	counter(c.hits, st.Hits)
+	counter(c.misses, st.Misses)
+	counter(c.expiredOnRead, st.ExpiredOnRead)
+	counter(c.writes, st.Writes)
+	counter(c.deletes, st.Deletes)
+	counter(c.evictions, st.EvictionsMaxBytes, "max_bytes")
+	counter(c.evictions, st.EvictionsMaxEntries, "max_entries")
+	counter(c.sweeps, st.SweepRuns)
+	counter(c.sweepRemoved, st.SweepRemoved)
+	counter(c.loaderCalls, st.LoaderCalls)
+	counter(c.loaderErrors, st.LoaderErrors)
+	gauge(c.writeBehindQueued, float64(st.WriteBehindQueued))
+	counter(c.writeBehindFlushed, st.WriteBehindFlushed)
+	counter(c.writeBehindFailures, st.WriteBehindFailures)
+
+	c.readDuration.Collect(ch)
+	c.writeDuration.Collect(ch)
+	c.sweepDuration.Collect(ch)
+}
+
+// ObserveRead implements memorystorecache.Observer
+func (c *Collector) ObserveRead(d time.Duration, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	c.readDuration.WithLabelValues(result).Observe(d.Seconds())
+}
+
+// ObserveWrite implements memorystorecache.Observer
+func (c *Collector) ObserveWrite(d time.Duration) {
+	c.writeDuration.Observe(d.Seconds())
+}
+
+// ObserveSweep implements memorystorecache.Observer
+func (c *Collector) ObserveSweep(d time.Duration, removed uint64) {
+	c.sweepDuration.Observe(d.Seconds())
+}
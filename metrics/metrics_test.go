@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+func scrape(t *testing.T, reg *prometheus.Registry) string {
+	srv := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+func TestCollector(t *testing.T) {
+	c, err := memorystorecache.NewCache(time.Minute, 0, memorystorecache.WithMaxEntries(1), memorystorecache.WithShards(1))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	reg := prometheus.NewRegistry()
+	_, err = Register(reg, conn, Opts{
+		Namespace:   "podcasts",
+		ConstLabels: prometheus.Labels{"cache": "episodes"},
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1, 2}))
+	assert.Nil(t, conn.Write([]byte("b"), []byte{1, 2}))
+	_, err = conn.Read([]byte("b"))
+	assert.Nil(t, err)
+	_, err = conn.Read([]byte("a"))
+	assert.NotNil(t, err)
+
+	body := scrape(t, reg)
+	for _, line := range []string{
+		`podcasts_memorystore_keys{cache="episodes"} 1`,
+		`podcasts_memorystore_bytes{cache="episodes"} 3`,
+		`podcasts_memorystore_hits_total{cache="episodes"} 1`,
+		`podcasts_memorystore_misses_total{cache="episodes"} 1`,
+		`podcasts_memorystore_writes_total{cache="episodes"} 2`,
+		`podcasts_memorystore_evictions_total{cache="episodes",cause="max_entries"} 1`,
+		`podcasts_memorystore_evictions_total{cache="episodes",cause="max_bytes"} 0`,
+		`podcasts_memorystore_read_duration_seconds_count{cache="episodes",result="hit"} 1`,
+		`podcasts_memorystore_read_duration_seconds_count{cache="episodes",result="miss"} 1`,
+		`podcasts_memorystore_write_duration_seconds_count{cache="episodes"} 2`,
+		`podcasts_memorystore_sweep_duration_seconds_count{cache="episodes"} 0`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	c, err := memorystorecache.NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	reg := prometheus.NewRegistry()
+	_, err = Register(reg, conn, Opts{})
+	assert.Nil(t, err)
+	_, err = Register(reg, conn, Opts{})
+	assert.NotNil(t, err)
+
+	// the first collector still observes reads
+	_, err = conn.Read([]byte("key"))
+	assert.NotNil(t, err)
+	assert.Contains(t, scrape(t, reg), `memorystore_read_duration_seconds_count{result="miss"} 1`)
+}
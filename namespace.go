@@ -0,0 +1,194 @@
+package memorystorecache
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrNamespaceQuota is returned when a write would put a namespace over its quota
+	ErrNamespaceQuota = errors.New("Namespace quota exceeded")
+	// ErrNamespaceExists is returned when a connection already has a namespace with the same name
+	ErrNamespaceExists = errors.New("Namespace already exists")
+)
+
+// namespaceSep ends a namespace's name in the keys it stores
+const namespaceSep = "\x00"
+
+// Namespace is a named view of a connection with its own default TTL and memory quota
+// its keys are stored in the connection prefixed with the namespace name and a NUL byte,
+// so namespaces share the connection's memory budget without their keys colliding
+type Namespace struct {
+	bytes int64
+
+	TTL      time.Duration
+	name     string
+	prefix   string
+	maxBytes int64
+	conn     *Conn
+
+	// mu serializes quota checks so concurrent writes to different shards cannot overshoot the quota
+	mu sync.Mutex
+}
+
+// Namespace creates a namespace of the connection
+// keys written through it default to ttl, and a maxBytes of 0 leaves it bounded only by the connection's budget
+// keys already stored under the namespace, such as ones restored from a snapshot or append log, count against its quota
+func (c *Conn) Namespace(name string, ttl time.Duration, maxBytes int64) (*Namespace, error) {
+	if strings.Contains(name, namespaceSep) {
+		return nil, errors.New("namespace name must not contain a NUL byte")
+	}
+	if maxBytes < 0 {
+		return nil, errors.New("namespace quota must not be negative")
+	}
+
+	ns := &Namespace{
+		TTL:      ttl,
+		name:     name,
+		prefix:   name + namespaceSep,
+		maxBytes: maxBytes,
+		conn:     c,
+	}
+
+	c.nsMu.Lock()
+	old, _ := c.namespaces.Load().(map[string]*Namespace)
+	if _, exists := old[name]; exists {
+		c.nsMu.Unlock()
+		return nil, ErrNamespaceExists
+	}
+	m := make(map[string]*Namespace, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[name] = ns
+	c.namespaces.Store(m)
+	c.nsMu.Unlock()
+
+	// charge keys written before the namespace existed, later writes are charged as they are stored
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		for key, el := range s.dat {
+			if el.ns == nil && strings.HasPrefix(key, ns.prefix) {
+				el.ns = ns
+				s.dat[key] = el
+				ns.charge(el.size(key))
+			}
+		}
+		s.mu.Unlock()
+	}
+	return ns, nil
+}
+
+// FlushNamespace removes every key in a namespace
+func (c *Conn) FlushNamespace(name string) error {
+	return c.flushPrefix(name + namespaceSep)
+}
+
+// namespaceFor returns the namespace a key is stored under, or nil
+func (c *Conn) namespaceFor(key string) *Namespace {
+	m, _ := c.namespaces.Load().(map[string]*Namespace)
+	if len(m) == 0 {
+		return nil
+	}
+	i := strings.Index(key, namespaceSep)
+	if i < 0 {
+		return nil
+	}
+	return m[key[:i]]
+}
+
+// setNamespacedLocked stores an element, charging it to its key's namespace
+// nothing is stored if the namespace would go over its quota
+// the caller must hold the shard's write lock
+func (c *Conn) setNamespacedLocked(s *shard, key string, el cacheElement) (cacheElement, error) {
+	el.ns = c.namespaceFor(key)
+	if el.ns == nil || el.ns.maxBytes == 0 {
+		s.setLocked(key, el)
+		return el, nil
+	}
+
+	ns := el.ns
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	grow := el.size(key)
+	if old, exists := s.dat[key]; exists && old.ns == ns {
+		grow -= old.size(key)
+	}
+	// removals only free space, so they are not serialized with the check
+	if grow > 0 && atomic.LoadInt64(&ns.bytes)+grow > ns.maxBytes {
+		return el, ErrNamespaceQuota
+	}
+	s.setLocked(key, el)
+	return el, nil
+}
+
+// charge adds n bytes to the namespace's usage, a nil namespace is a noop
+func (ns *Namespace) charge(n int64) {
+	if ns != nil {
+		atomic.AddInt64(&ns.bytes, n)
+	}
+}
+
+// Name returns the namespace's name
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Bytes returns the bytes the namespace's keys, including their prefixes, count against its quota
+// expired keys count until they are removed
+func (ns *Namespace) Bytes() int64 {
+	return atomic.LoadInt64(&ns.bytes)
+}
+
+// key prefixes a key with the namespace
+func (ns *Namespace) key(k []byte) []byte {
+	return append([]byte(ns.prefix), k...)
+}
+
+// Read retrieves data for a key from the namespace
+func (ns *Namespace) Read(k []byte) ([]byte, error) {
+	return ns.conn.Read(ns.key(k))
+}
+
+// Write writes data to the namespace with its default TTL
+func (ns *Namespace) Write(k, v []byte) error {
+	return ns.conn.WriteTTL(ns.key(k), v, ns.TTL)
+}
+
+// WriteTTL writes data to the namespace with an explicit TTL
+func (ns *Namespace) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return ns.conn.WriteTTL(ns.key(k), v, ttl)
+}
+
+// Delete removes a key from the namespace
+func (ns *Namespace) Delete(k []byte) error {
+	return ns.conn.Delete(ns.key(k))
+}
+
+// Exists reports whether an unexpired key is in the namespace
+func (ns *Namespace) Exists(k []byte) bool {
+	return ns.conn.Exists(ns.key(k))
+}
+
+// Touch resets the TTL of a key to the namespace's default
+func (ns *Namespace) Touch(k []byte) error {
+	return ns.conn.TouchTTL(ns.key(k), ns.TTL)
+}
+
+// Keys returns the namespace's unexpired keys starting with prefix, without the namespace prefix
+func (ns *Namespace) Keys(prefix []byte) [][]byte {
+	keys := ns.conn.Keys(ns.key(prefix))
+	for i, k := range keys {
+		keys[i] = k[len(ns.prefix):]
+	}
+	return keys
+}
+
+// Flush removes every key in the namespace
+func (ns *Namespace) Flush() error {
+	return ns.conn.FlushNamespace(ns.name)
+}
@@ -0,0 +1,134 @@
+package memorystorecache
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	users, err := conn.Namespace("users", time.Second, 0)
+	assert.Nil(t, err)
+	posts, err := conn.Namespace("posts", time.Hour, 0)
+	assert.Nil(t, err)
+	_, err = conn.Namespace("users", time.Second, 0)
+	assert.Equal(t, ErrNamespaceExists, err)
+	_, err = conn.Namespace("bad\x00name", time.Second, 0)
+	assert.NotNil(t, err)
+
+	// the same key in different namespaces does not collide
+	assert.Nil(t, users.Write([]byte("1"), []byte("alice")))
+	assert.Nil(t, posts.Write([]byte("1"), []byte("hello")))
+	assert.Nil(t, conn.Write([]byte("1"), []byte("plain")))
+	b, err := users.Read([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), b)
+	b, err = posts.Read([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), b)
+	assert.Equal(t, [][]byte{[]byte("1")}, users.Keys(nil))
+	assert.Equal(t, "users", users.Name())
+
+	// each namespace has its own default TTL
+	clk.Advance(time.Second)
+	assert.False(t, users.Exists([]byte("1")))
+	assert.True(t, posts.Exists([]byte("1")))
+
+	assert.Nil(t, posts.Write([]byte("2"), []byte("world")))
+	assert.Nil(t, conn.FlushNamespace("posts"))
+	assert.False(t, posts.Exists([]byte("1")))
+	assert.False(t, posts.Exists([]byte("2")))
+	assert.True(t, conn.Exists([]byte("1")))
+	assert.Equal(t, int64(0), posts.Bytes())
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// each key costs the prefix "ns\x00", the key and the value
+	ns, err := conn.Namespace("ns", time.Minute, 20)
+	assert.Nil(t, err)
+	assert.Nil(t, ns.Write([]byte("a"), []byte("123456")))
+	assert.Equal(t, int64(10), ns.Bytes())
+	assert.Nil(t, ns.Write([]byte("b"), []byte("123456")))
+	assert.Equal(t, ErrNamespaceQuota, ns.Write([]byte("c"), []byte{1}))
+	assert.False(t, ns.Exists([]byte("c")))
+
+	// overwriting a key only needs room for the difference
+	assert.Nil(t, ns.Write([]byte("a"), []byte("12345")))
+	assert.Equal(t, ErrNamespaceQuota, ns.Write([]byte("a"), []byte("1234567")))
+	assert.Equal(t, int64(19), ns.Bytes())
+
+	// keys outside the namespace are not limited by it
+	assert.Nil(t, conn.Write([]byte("other"), make([]byte, 100)))
+
+	assert.Nil(t, ns.Delete([]byte("b")))
+	assert.Equal(t, int64(9), ns.Bytes())
+	assert.Nil(t, ns.Write([]byte("c"), []byte{1}))
+
+	assert.Nil(t, ns.Flush())
+	assert.Equal(t, int64(0), ns.Bytes())
+}
+
+func TestNamespaceQuotaConcurrent(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	ns, err := conn.Namespace("ns", time.Minute, 1000)
+	assert.Nil(t, err)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ns.Write([]byte(fmt.Sprintf("%d-%d", i, j)), make([]byte, 10))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.True(t, ns.Bytes() <= 1000)
+	assert.True(t, ns.Bytes() > 900)
+}
+
+func TestNamespaceReplayed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncAlways))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+	ns, err := conn.Namespace("ns", time.Minute, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, ns.Write([]byte("a"), []byte("123456")))
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, int64(0), ns.Bytes())
+
+	// keys restored before the namespace is created count against its quota
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	defer conn.Close()
+	ns, err = conn.Namespace("ns", time.Minute, 15)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), ns.Bytes())
+	assert.Equal(t, ErrNamespaceQuota, ns.Write([]byte("b"), []byte("123456")))
+}
@@ -0,0 +1,252 @@
+// Package peer spreads a loader backed cache over several processes, in the style of groupcache
+//
+// a consistent hash ring picks the peer owning each key; the owner loads and caches the key,
+// and other peers fetch it from the owner over HTTP, keeping a small replica of the keys they fetch most
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+// DefaultBasePath is the path peers serve keys under when Opts.BasePath is not set
+const DefaultBasePath = "/_peer/"
+
+// Opts configures a Group
+type Opts struct {
+	// Self is this peer's base URL as the other peers reach it, for example http://10.0.0.1:8080
+	Self string
+	// Peers are the base URLs of every peer, including Self
+	Peers []string
+	// VirtualNodes is how many points each peer gets on the ring, DefaultVirtualNodes if 0
+	VirtualNodes int
+	// BasePath is the path keys are served under, DefaultBasePath if empty
+	BasePath string
+
+	// TTL is how long the owner caches a loaded key, the connection's TTL if 0
+	TTL time.Duration
+	// HotTTL is how long a key fetched from its owner is kept locally, a minute if 0
+	HotTTL time.Duration
+	// HotEntries bounds the local replica of fetched keys, least frequently used keys are dropped first; 1000 if 0
+	HotEntries int
+
+	// Client fetches keys from other peers, a client with a 5 second timeout if nil
+	Client *http.Client
+}
+
+// Group is one peer's view of a cache spread across several peers
+// mount it on the peer's HTTP server at Opts.BasePath, e.g. mux.Handle(peer.DefaultBasePath, group)
+type Group struct {
+	stats counters
+
+	conn   *memorystorecache.Conn
+	hot    *memorystorecache.Conn
+	loader func(key string) ([]byte, error)
+
+	self     string
+	vnodes   int
+	basePath string
+	ttl      time.Duration
+	hotTTL   time.Duration
+	client   *http.Client
+
+	mu   sync.RWMutex
+	ring *Ring
+}
+
+// counters are a Group's atomically updated statistics
+type counters struct {
+	gets        uint64
+	localLoads  uint64
+	peerFetches uint64
+	peerErrors  uint64
+	served      uint64
+}
+
+// Stats describes the traffic of a Group
+type Stats struct {
+	Gets        uint64
+	LocalLoads  uint64 // loader calls, for owned keys or when the owner could not be reached
+	PeerFetches uint64 // keys fetched from their owner
+	PeerErrors  uint64 // fetches that failed and fell back to the loader
+	HotHits     uint64 // gets of other peers' keys answered by the local replica
+	Served      uint64 // requests from other peers
+}
+
+// NewGroup creates a peer caching keys it owns in conn and loading them with loader
+// loader may return memorystorecache.ErrKeyNotFound for keys that do not exist
+func NewGroup(conn *memorystorecache.Conn, loader func(key string) ([]byte, error), opts Opts) (*Group, error) {
+	if conn == nil || loader == nil {
+		return nil, errors.New("a connection and a loader are required")
+	}
+	if opts.Self == "" {
+		return nil, errors.New("self must be set")
+	}
+
+	g := &Group{
+		conn:     conn,
+		loader:   loader,
+		self:     strings.TrimSuffix(opts.Self, "/"),
+		vnodes:   opts.VirtualNodes,
+		basePath: opts.BasePath,
+		ttl:      opts.TTL,
+		hotTTL:   opts.HotTTL,
+		client:   opts.Client,
+	}
+	if g.basePath == "" {
+		g.basePath = DefaultBasePath
+	}
+	if !strings.HasSuffix(g.basePath, "/") {
+		g.basePath += "/"
+	}
+	if g.ttl == 0 {
+		g.ttl = conn.TTL
+	}
+	if g.hotTTL == 0 {
+		g.hotTTL = time.Minute
+	}
+	if g.client == nil {
+		g.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	hotEntries := opts.HotEntries
+	if hotEntries == 0 {
+		hotEntries = 1000
+	}
+
+	c, err := memorystorecache.NewCache(g.hotTTL, g.hotTTL,
+		memorystorecache.WithMaxEntries(hotEntries), memorystorecache.WithPolicy(memorystorecache.NewLFU))
+	if err != nil {
+		return nil, err
+	}
+	if g.hot, err = c.Open(""); err != nil {
+		return nil, err
+	}
+
+	g.SetPeers(opts.Peers...)
+	return g, nil
+}
+
+// SetPeers replaces the peers keys are spread across, Self is always included
+func (g *Group) SetPeers(peers ...string) {
+	ring := NewRing(g.vnodes)
+	ring.Add(g.self)
+	for _, p := range peers {
+		ring.Add(strings.TrimSuffix(p, "/"))
+	}
+	g.mu.Lock()
+	g.ring = ring
+	g.mu.Unlock()
+}
+
+// Owner returns the base URL of the peer owning key
+func (g *Group) Owner(key string) string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.ring.Get(key)
+}
+
+// Get returns a key's value, loading it if this peer owns it and fetching it from its owner otherwise
+// concurrent gets of a key share one load or fetch
+func (g *Group) Get(key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.gets, 1)
+	owner := g.Owner(key)
+	if owner == g.self {
+		return g.conn.GetOrLoad([]byte(key), g.ttl, func() ([]byte, error) {
+			return g.load(key)
+		})
+	}
+
+	return g.hot.GetOrLoad([]byte(key), g.hotTTL, func() ([]byte, error) {
+		v, err := g.fetch(owner, key)
+		if err == nil || err == memorystorecache.ErrKeyNotFound {
+			return v, err
+		}
+		// an unreachable owner should not make the key unavailable
+		atomic.AddUint64(&g.stats.peerErrors, 1)
+		return g.load(key)
+	})
+}
+
+// load calls the loader
+func (g *Group) load(key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.localLoads, 1)
+	return g.loader(key)
+}
+
+// fetch requests a key from its owner
+func (g *Group) fetch(owner, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.peerFetches, 1)
+	resp, err := g.client.Get(owner + g.basePath + url.PathEscape(key))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, memorystorecache.ErrKeyNotFound
+	}
+	return nil, fmt.Errorf("peer %s returned %s", owner, resp.Status)
+}
+
+// ServeHTTP serves keys to other peers, loading them without forwarding even if another peer owns them,
+// so peers with different views of the ring cannot send a request in circles
+func (g *Group) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, g.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(path, g.basePath))
+	if err != nil || key == "" {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+
+	atomic.AddUint64(&g.stats.served, 1)
+	v, err := g.conn.GetOrLoad([]byte(key), g.ttl, func() ([]byte, error) {
+		return g.load(key)
+	})
+	switch {
+	case err == memorystorecache.ErrKeyNotFound:
+		http.NotFound(w, r)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(v)
+	}
+}
+
+// Stats returns the group's statistics
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:        atomic.LoadUint64(&g.stats.gets),
+		LocalLoads:  atomic.LoadUint64(&g.stats.localLoads),
+		PeerFetches: atomic.LoadUint64(&g.stats.peerFetches),
+		PeerErrors:  atomic.LoadUint64(&g.stats.peerErrors),
+		HotHits:     g.hot.TypedStats().Hits,
+		Served:      atomic.LoadUint64(&g.stats.served),
+	}
+}
+
+// Close releases the local replica, the connection passed to NewGroup is left open
+func (g *Group) Close() error {
+	return g.hot.Close()
+}
@@ -0,0 +1,222 @@
+package peer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+// cluster is several groups serving each other on loopback listeners
+type cluster struct {
+	conns   []*memorystorecache.Conn
+	groups  []*Group
+	servers []*httptest.Server
+
+	mu    sync.Mutex
+	loads map[string]int
+}
+
+func newCluster(t *testing.T, n int) *cluster {
+	cl := &cluster{loads: map[string]int{}}
+	var urls []string
+	var muxes []*http.ServeMux
+	for i := 0; i < n; i++ {
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		cl.servers = append(cl.servers, srv)
+		muxes = append(muxes, mux)
+		urls = append(urls, srv.URL)
+	}
+
+	for i := 0; i < n; i++ {
+		c, err := memorystorecache.NewCache(time.Minute, 0)
+		assert.Nil(t, err)
+		conn, err := c.Open("")
+		assert.Nil(t, err)
+		g, err := NewGroup(conn, cl.load, Opts{Self: urls[i], Peers: urls})
+		assert.Nil(t, err)
+		muxes[i].Handle(DefaultBasePath, g)
+		cl.conns = append(cl.conns, conn)
+		cl.groups = append(cl.groups, g)
+	}
+	return cl
+}
+
+// load is every peer's loader, keys starting with "missing" do not exist
+func (cl *cluster) load(key string) ([]byte, error) {
+	cl.mu.Lock()
+	cl.loads[key]++
+	cl.mu.Unlock()
+	if strings.HasPrefix(key, "missing") {
+		return nil, memorystorecache.ErrKeyNotFound
+	}
+	return []byte("value of " + key), nil
+}
+
+func (cl *cluster) loaded(key string) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.loads[key]
+}
+
+// index returns the index of the group at url
+func (cl *cluster) index(url string) int {
+	for i, srv := range cl.servers {
+		if srv.URL == url {
+			return i
+		}
+	}
+	return -1
+}
+
+func (cl *cluster) close() {
+	for i := range cl.groups {
+		cl.servers[i].Close()
+		cl.groups[i].Close()
+		cl.conns[i].Close()
+	}
+}
+
+func TestGroupGet(t *testing.T) {
+	cl := newCluster(t, 3)
+	defer cl.close()
+
+	// every peer agrees on the owner, and each key is loaded once across the cluster
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key/%d ?", i)
+		owner := cl.groups[0].Owner(key)
+		for _, g := range cl.groups {
+			assert.Equal(t, owner, g.Owner(key))
+			v, err := g.Get(key)
+			assert.Nil(t, err)
+			assert.Equal(t, "value of "+key, string(v))
+		}
+		assert.Equal(t, 1, cl.loaded(key), key)
+	}
+
+	var st Stats
+	for _, g := range cl.groups {
+		s := g.Stats()
+		st.LocalLoads += s.LocalLoads
+		st.PeerFetches += s.PeerFetches
+		st.Served += s.Served
+	}
+	assert.Equal(t, uint64(30), st.LocalLoads)
+	assert.Equal(t, uint64(60), st.PeerFetches)
+	assert.Equal(t, uint64(60), st.Served)
+}
+
+func TestGroupHotReplica(t *testing.T) {
+	cl := newCluster(t, 3)
+	defer cl.close()
+
+	key := "hot"
+	owner := cl.index(cl.groups[0].Owner(key))
+	g := cl.groups[(owner+1)%3]
+
+	// a key fetched from its owner is answered locally after that
+	for i := 0; i < 5; i++ {
+		v, err := g.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, "value of hot", string(v))
+	}
+	st := g.Stats()
+	assert.Equal(t, uint64(1), st.PeerFetches)
+	assert.Equal(t, uint64(4), st.HotHits)
+	assert.Equal(t, uint64(1), cl.groups[owner].Stats().Served)
+}
+
+func TestGroupNotFound(t *testing.T) {
+	cl := newCluster(t, 3)
+	defer cl.close()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("missing-%d", i)
+		for _, g := range cl.groups {
+			_, err := g.Get(key)
+			assert.Equal(t, memorystorecache.ErrKeyNotFound, err)
+		}
+	}
+	for _, g := range cl.groups {
+		assert.Equal(t, uint64(0), g.Stats().PeerErrors)
+	}
+}
+
+func TestGroupOwnerDown(t *testing.T) {
+	cl := newCluster(t, 3)
+	defer cl.close()
+
+	key := "key"
+	owner := cl.index(cl.groups[0].Owner(key))
+	g := cl.groups[(owner+1)%3]
+	cl.servers[owner].Close()
+
+	// an unreachable owner falls back to loading locally
+	v, err := g.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, "value of key", string(v))
+	st := g.Stats()
+	assert.Equal(t, uint64(1), st.PeerErrors)
+	assert.Equal(t, uint64(1), st.LocalLoads)
+
+	// and once the peers are updated the key belongs to a live peer
+	var live []string
+	for i, srv := range cl.servers {
+		if i != owner {
+			live = append(live, srv.URL)
+		}
+	}
+	g.SetPeers(live...)
+	assert.NotEqual(t, cl.servers[owner].URL, g.Owner(key))
+}
+
+func TestGroupServeHTTP(t *testing.T) {
+	cl := newCluster(t, 1)
+	defer cl.close()
+	url := cl.servers[0].URL + DefaultBasePath
+
+	resp, err := http.Post(url+"key", "text/plain", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Get(url)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(url + "missing")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestNewGroupValidation(t *testing.T) {
+	c, err := memorystorecache.NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+	load := func(string) ([]byte, error) { return nil, nil }
+
+	_, err = NewGroup(nil, load, Opts{Self: "http://a"})
+	assert.NotNil(t, err)
+	_, err = NewGroup(conn, nil, Opts{Self: "http://a"})
+	assert.NotNil(t, err)
+	_, err = NewGroup(conn, load, Opts{})
+	assert.NotNil(t, err)
+
+	// alone on the ring a group owns every key
+	g, err := NewGroup(conn, load, Opts{Self: "http://a/"})
+	assert.Nil(t, err)
+	defer g.Close()
+	assert.Equal(t, "http://a", g.Owner("key"))
+}
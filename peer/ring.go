@@ -0,0 +1,66 @@
+package peer
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is how many points each peer gets on a ring when none is given
+const DefaultVirtualNodes = 50
+
+// Ring is a consistent hash ring mapping keys to peers
+// every peer is hashed onto the ring at several virtual nodes, so keys spread evenly
+// and adding or removing a peer only moves the keys it gains or loses
+// a Ring is not safe for concurrent use while peers are added
+type Ring struct {
+	vnodes int
+	hashes []uint32 // sorted
+	owners map[uint32]string
+}
+
+// NewRing creates an empty ring placing each peer at vnodes points, DefaultVirtualNodes if vnodes <= 0
+func NewRing(vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	return &Ring{vnodes: vnodes, owners: map[uint32]string{}}
+}
+
+// Add places peers on the ring
+func (r *Ring) Add(peers ...string) {
+	for _, p := range peers {
+		for i := 0; i < r.vnodes; i++ {
+			h := hash(strconv.Itoa(i) + "-" + p)
+			// on the rare collision the lowest peer keeps the point, whatever order peers are added in
+			if owner, exists := r.owners[h]; exists && owner < p {
+				continue
+			} else if !exists {
+				r.hashes = append(r.hashes, h)
+			}
+			r.owners[h] = p
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Get returns the peer owning key, the first peer clockwise from the key's hash
+// it returns "" for an empty ring
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// hash places keys and virtual nodes on the ring with 32-bit FNV-1a
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
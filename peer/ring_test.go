@@ -0,0 +1,55 @@
+package peer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(0)
+	assert.Equal(t, "", r.Get("key"))
+
+	peers := []string{"http://a", "http://b", "http://c"}
+	r.Add(peers...)
+
+	// keys spread over every peer
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		counts[r.Get(fmt.Sprintf("key-%d", i))]++
+	}
+	assert.Len(t, counts, 3)
+	for _, p := range peers {
+		assert.True(t, counts[p] > 500, "%s owns %d keys", p, counts[p])
+	}
+
+	// the order peers are added in does not matter
+	r2 := NewRing(0)
+	r2.Add("http://c", "http://a", "http://b")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, r.Get(key), r2.Get(key))
+	}
+}
+
+func TestRingAddMovesFewKeys(t *testing.T) {
+	r := NewRing(0)
+	r.Add("http://a", "http://b", "http://c")
+	before := map[string]string{}
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		before[key] = r.Get(key)
+	}
+
+	// a new peer only takes keys, other peers keep the rest
+	r.Add("http://d")
+	moved := 0
+	for key, owner := range before {
+		if now := r.Get(key); now != owner {
+			assert.Equal(t, "http://d", now)
+			moved++
+		}
+	}
+	assert.True(t, moved > 500 && moved < 1500, "%d keys moved", moved)
+}
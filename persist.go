@@ -0,0 +1,487 @@
+package memorystorecache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy controls how often the append log is synced to disk
+type FsyncPolicy int
+
+const (
+	// FsyncEverySecond syncs the append log once a second, losing at most a second of writes on a crash
+	FsyncEverySecond FsyncPolicy = iota
+	// FsyncAlways syncs the append log after every write
+	FsyncAlways
+	// FsyncNever leaves syncing the append log to the operating system
+	FsyncNever
+)
+
+// append log layout
+//
+//	header: "MSLG" version(1 byte)
+//	record: op(1 byte) payloadLen(uvarint) payload crc32(4 bytes, big endian, of op and payload)
+//
+// a set payload is an element encoded as in snapshots of the same version, a delete payload is the key
+// logs of an older version are rewritten in the current version when opened
+const (
+	logMagic   = "MSLG"
+	logVersion = 3
+	logFile    = "memorystore.log"
+
+	opSet    = 1
+	opDelete = 2
+
+	// the log is compacted once it has doubled since the last compaction and is at least this big
+	minCompactSize = 1 << 20
+)
+
+// ErrInvalidLog is returned when an append log was not written by this package, or has a corrupt record before its end
+var ErrInvalidLog = errors.New("Invalid append log")
+
+// WithAppendLog persists every write and delete to an append only log
+// the log is kept in the directory passed to Open and replayed when it is opened again
+func WithAppendLog(policy FsyncPolicy) Option {
+	return func(c *Cache) error {
+		if policy < FsyncEverySecond || policy > FsyncNever {
+			return errors.New("unknown fsync policy")
+		}
+		c.appendLog = true
+		c.fsync = policy
+		return nil
+	}
+}
+
+// WithCompactInterval sets how often the append log is checked for compaction
+// it defaults to a minute, an interval of 0 disables background compaction
+func WithCompactInterval(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return errors.New("compact interval must not be negative")
+		}
+		c.compactInterval = d
+		return nil
+	}
+}
+
+// appendLog is an append only log of writes and deletes
+type appendLog struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	policy FsyncPolicy
+	size   int64 // bytes in the current file
+	base   int64 // size of the file after the last compaction
+	dirty  bool  // written since the last fsync
+	err    error // the first write error, returned by every later append
+
+	// rewrite collects records appended while a compaction runs, nil otherwise
+	rewrite *bytes.Buffer
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// openAppendLog replays the log in dir and opens it for appending
+func (c *Conn) openAppendLog(dir string, policy FsyncPolicy, compactInterval time.Duration) error {
+	if dir == "" {
+		return errors.New("a data directory is required for the append log")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, logFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	size, version, err := c.replayLog(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// drop a torn record left by a crash mid write
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	l := &appendLog{
+		path:   path,
+		f:      f,
+		w:      bufio.NewWriter(f),
+		policy: policy,
+		size:   size,
+		base:   size,
+		done:   make(chan struct{}),
+	}
+	if size == 0 {
+		l.w.WriteString(logMagic)
+		l.w.WriteByte(logVersion)
+		l.size = int64(len(logMagic) + 1)
+		l.base = l.size
+		if err := l.w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	c.aof = l
+
+	// records appended to an older log must not mix versions, so rewrite it first
+	if size > 0 && version < logVersion {
+		if err := c.Compact(); err != nil {
+			c.aof = nil
+			l.f.Close()
+			return err
+		}
+	}
+
+	l.wg.Add(1)
+	go c.maintainLog(compactInterval)
+	return nil
+}
+
+// replayLog applies every complete record in f, returning the offset after the last one and the log's version
+// a record cut short at the end of the file is left for the caller to truncate,
+// while a corrupt record with more data after it fails with ErrInvalidLog so later records are not lost
+func (c *Conn) replayLog(f *os.File) (int64, byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	r := bufio.NewReader(f)
+	var header [len(logMagic) + 1]byte
+	n, err := io.ReadFull(r, header[:])
+	if n == 0 && err == io.EOF {
+		return 0, logVersion, nil
+	}
+	if err != nil || string(header[:len(logMagic)]) != logMagic {
+		return 0, 0, ErrInvalidLog
+	}
+	version := header[len(logMagic)]
+	if version < 1 || version > logVersion {
+		return 0, 0, ErrInvalidLog
+	}
+
+	offset := int64(len(header))
+	t := c.now()
+	for {
+		op, payload, n, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a clean end of file or a torn tail
+			return offset, version, nil
+		}
+		if err != nil {
+			return tornTail(r, offset, version)
+		}
+
+		switch op {
+		case opSet:
+			key, el, err := decodeElement(bytes.NewReader(payload), version)
+			if err != nil {
+				return tornTail(r, offset, version)
+			}
+			offset += n
+			if el.expired(t) {
+				c.removeKey(key)
+				continue
+			}
+			el.fill = true
+			c.writeElement(key, el)
+		case opDelete:
+			offset += n
+			c.removeKey(string(payload))
+		default:
+			offset += n
+		}
+	}
+}
+
+// tornTail treats a bad record at offset as a torn write if nothing follows it, and as a corrupt log otherwise
+func tornTail(r *bufio.Reader, offset int64, version byte) (int64, byte, error) {
+	if _, err := r.Peek(1); err == io.EOF {
+		return offset, version, nil
+	}
+	return 0, 0, ErrInvalidLog
+}
+
+// removeKey deletes a key without logging it
+func (c *Conn) removeKey(key string) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	s.removeLocked(key, EvictDeleted)
+	s.mu.Unlock()
+}
+
+// readRecord reads one record of at most remaining bytes, returning its op, payload and encoded length
+// a record running past remaining fails with io.ErrUnexpectedEOF
+func readRecord(r *bufio.Reader, remaining int64) (byte, []byte, int64, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if n > uint64(remaining) {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+	payload, err := readBytesN(r, n)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return 0, nil, 0, err
+	}
+
+	h := crc32.NewIEEE()
+	h.Write([]byte{op})
+	h.Write(payload)
+	if binary.BigEndian.Uint32(sum[:]) != h.Sum32() {
+		return 0, nil, 0, ErrInvalidLog
+	}
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	return op, payload, int64(1 + binary.PutUvarint(lenBuf[:], n) + len(payload) + 4), nil
+}
+
+// appendRecord encodes a record onto w
+func appendRecord(w encodeWriter, op byte, payload []byte) {
+	h := crc32.NewIEEE()
+	h.Write([]byte{op})
+	h.Write(payload)
+
+	w.WriteByte(op)
+	writeUvarint(w, uint64(len(payload)))
+	w.Write(payload)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], h.Sum32())
+	w.Write(sum[:])
+}
+
+// append writes a record to the log, syncing it if the policy requires
+func (l *appendLog) append(op byte, payload []byte) error {
+	var rec bytes.Buffer
+	appendRecord(&rec, op, payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if l.rewrite != nil {
+		l.rewrite.Write(rec.Bytes())
+	}
+	l.w.Write(rec.Bytes())
+	l.size += int64(rec.Len())
+	if err := l.w.Flush(); err != nil {
+		l.err = err
+		return err
+	}
+	if l.policy == FsyncAlways {
+		if err := l.f.Sync(); err != nil {
+			l.err = err
+			return err
+		}
+		return nil
+	}
+	l.dirty = true
+	return nil
+}
+
+// logSet records a write, the caller must hold the key's shard lock so records stay in order
+func (c *Conn) logSet(key string, el cacheElement) error {
+	if c.aof == nil {
+		return nil
+	}
+	var payload bytes.Buffer
+	encodeElement(&payload, key, el)
+	return c.aof.append(opSet, payload.Bytes())
+}
+
+// logDelete records a delete, the caller must hold the key's shard lock so records stay in order
+func (c *Conn) logDelete(key string) error {
+	if c.aof == nil {
+		return nil
+	}
+	return c.aof.append(opDelete, []byte(key))
+}
+
+// sync flushes the log to disk if it was written since the last sync
+func (l *appendLog) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dirty || l.err != nil {
+		return l.err
+	}
+	l.dirty = false
+	return l.f.Sync()
+}
+
+// maintainLog syncs the log every second and compacts it every compactInterval
+func (c *Conn) maintainLog(compactInterval time.Duration) {
+	l := c.aof
+	defer l.wg.Done()
+
+	syncTicker := c.clock.NewTicker(time.Second)
+	defer syncTicker.Stop()
+	var compactC <-chan time.Time
+	if compactInterval > 0 {
+		compactTicker := c.clock.NewTicker(compactInterval)
+		defer compactTicker.Stop()
+		compactC = compactTicker.C()
+	}
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-syncTicker.C():
+			if l.policy == FsyncEverySecond {
+				l.sync()
+			}
+		case <-compactC:
+			l.mu.Lock()
+			due := l.size >= minCompactSize && l.size >= 2*l.base
+			l.mu.Unlock()
+			if due {
+				c.Compact()
+			}
+		}
+	}
+}
+
+// Compact rewrites the append log to hold only live, unexpired keys
+// writes continue during compaction and are carried over to the new log
+func (c *Conn) Compact() error {
+	l := c.aof
+	if l == nil {
+		return errors.New("append log is not enabled")
+	}
+
+	l.mu.Lock()
+	if l.rewrite != nil {
+		l.mu.Unlock()
+		return errors.New("compaction is already running")
+	}
+	l.rewrite = &bytes.Buffer{}
+	l.mu.Unlock()
+
+	tmp := l.path + ".tmp"
+	f, err := c.writeCompacted(tmp)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.rewrite
+	l.rewrite = nil
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// records written since the shards were copied replay on top of them
+	if _, err := f.Write(pending.Bytes()); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(l.path))
+
+	l.w.Flush()
+	l.f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		l.err = err
+		return err
+	}
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	l.size = fi.Size()
+	l.base = l.size
+	l.dirty = false
+	return nil
+}
+
+// writeCompacted writes a log holding the live keys of every shard to path
+func (c *Conn) writeCompacted(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(logMagic)
+	w.WriteByte(logVersion)
+
+	var entries []snapshotEntry
+	var payload bytes.Buffer
+	for i := range c.shards {
+		entries = c.copyShard(i, entries[:0])
+		for _, e := range entries {
+			payload.Reset()
+			encodeElement(&payload, e.key, e.el)
+			appendRecord(w, opSet, payload.Bytes())
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// syncDir syncs a directory so a rename within it is durable
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// close stops background maintenance and syncs the log
+func (l *appendLog) close() error {
+	close(l.done)
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,277 @@
+package memorystorecache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "memorystore")
+	assert.Nil(t, err)
+	return dir
+}
+
+func TestAppendLogReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncAlways), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1}))
+	assert.Nil(t, conn.Write([]byte("b"), []byte{2}))
+	assert.Nil(t, conn.Write([]byte("a"), []byte{3}))
+	assert.Nil(t, conn.Delete([]byte("b")))
+	assert.Nil(t, conn.WriteTTL([]byte("c"), []byte{4}, 100*time.Millisecond))
+	assert.Nil(t, conn.TouchTTL([]byte("c"), 0))
+	assert.Nil(t, conn.WriteTTL([]byte("d"), []byte{5}, 100*time.Millisecond))
+	assert.Nil(t, conn.Close())
+
+	clk.Advance(100 * time.Millisecond)
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	b, err := conn.Read([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, b)
+	assert.False(t, conn.Exists([]byte("b")))
+	assert.True(t, conn.Exists([]byte("c")))
+	// expired keys are not restored
+	assert.False(t, conn.Exists([]byte("d")))
+	assert.Equal(t, uint64(2), conn.keyCount())
+}
+
+func TestAppendLogFlush(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncAlways))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1}))
+	assert.Nil(t, conn.Flush())
+	assert.Nil(t, conn.Write([]byte("b"), []byte{2}))
+	assert.Nil(t, conn.Close())
+
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.False(t, conn.Exists([]byte("a")))
+	assert.True(t, conn.Exists([]byte("b")))
+}
+
+func TestAppendLogTornTail(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncNever))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1}))
+	assert.Nil(t, conn.Close())
+
+	// simulate a crash part way through a record
+	path := filepath.Join(dir, logFile)
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	f.Write([]byte{opSet, 10, 1, 2})
+	f.Close()
+
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	assert.True(t, conn.Exists([]byte("a")))
+	assert.Nil(t, conn.Write([]byte("b"), []byte{2}))
+	assert.Nil(t, conn.Close())
+
+	// the torn record was dropped before appending
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.True(t, conn.Exists([]byte("a")))
+	assert.True(t, conn.Exists([]byte("b")))
+	fi2, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.True(t, fi2.Size() > fi.Size())
+}
+
+func TestAppendLogCorruptLength(t *testing.T) {
+	for name, rec := range map[string][]byte{
+		"over MaxInt64": {opSet, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"over MaxInt32": {opSet, 0x80, 0x80, 0x80, 0x80, 0x10, 1, 2},
+		"past the end":  {opDelete, 0x80, 0x80, 0x01, 'a'},
+	} {
+		dir := tempDir(t)
+		c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncNever))
+		assert.Nil(t, err)
+		conn, err := c.Open(dir)
+		assert.Nil(t, err)
+		assert.Nil(t, conn.Write([]byte("a"), []byte{1}))
+		assert.Nil(t, conn.Close())
+
+		f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0644)
+		assert.Nil(t, err)
+		f.Write(rec)
+		f.Close()
+
+		// replay stops at the last good record
+		conn, err = c.Open(dir)
+		assert.Nil(t, err, name)
+		if err == nil {
+			assert.True(t, conn.Exists([]byte("a")), name)
+			assert.Equal(t, uint64(1), conn.keyCount(), name)
+			conn.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAppendLogCorruptRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncNever))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+	for _, k := range []string{"a", "b", "c"} {
+		assert.Nil(t, conn.Write([]byte(k), []byte{1}))
+	}
+	assert.Nil(t, conn.Close())
+
+	path := filepath.Join(dir, logFile)
+	good, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	// a bad byte with records after it fails rather than dropping them
+	bad := append([]byte(nil), good...)
+	bad[len(logMagic)+3] ^= 0xff
+	assert.Nil(t, ioutil.WriteFile(path, bad, 0644))
+	_, err = c.Open(dir)
+	assert.Equal(t, ErrInvalidLog, err)
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, bad, b)
+
+	// a bad last record is dropped like a torn write
+	bad = append([]byte(nil), good...)
+	bad[len(bad)-1] ^= 0xff
+	assert.Nil(t, ioutil.WriteFile(path, bad, 0644))
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	assert.True(t, conn.Exists([]byte("a")))
+	assert.True(t, conn.Exists([]byte("b")))
+	assert.False(t, conn.Exists([]byte("c")))
+	conn.Close()
+}
+
+func TestAppendLogInvalid(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncEverySecond))
+	assert.Nil(t, err)
+	_, err = c.Open("")
+	assert.EqualError(t, err, "a data directory is required for the append log")
+
+	err = ioutil.WriteFile(filepath.Join(dir, logFile), []byte("not a log"), 0644)
+	assert.Nil(t, err)
+	_, err = c.Open(dir)
+	assert.Equal(t, ErrInvalidLog, err)
+
+	_, err = NewCache(time.Minute, 0, WithAppendLog(FsyncPolicy(10)))
+	assert.EqualError(t, err, "unknown fsync policy")
+	_, err = NewCache(time.Minute, 0, WithCompactInterval(-1))
+	assert.EqualError(t, err, "compact interval must not be negative")
+}
+
+func TestCompact(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncNever), WithCompactInterval(0))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 10; j++ {
+			assert.Nil(t, conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte{byte(j)}))
+		}
+	}
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, conn.Delete([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	path := filepath.Join(dir, logFile)
+	before, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Compact())
+	after, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.True(t, after.Size() < before.Size()/10)
+
+	// the compacted log keeps accepting writes
+	assert.Nil(t, conn.Write([]byte("new"), []byte{1}))
+	assert.Nil(t, conn.Close())
+
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, uint64(51), conn.keyCount())
+	b, err := conn.Read([]byte("key-0"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{9}, b)
+	assert.True(t, conn.Exists([]byte("new")))
+}
+
+func TestCompactWithoutLog(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.EqualError(t, conn.Compact(), "append log is not enabled")
+}
+
+func TestAppendLogVersion1(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// a version 1 element is a current element without the trailing tag count and flags
+	var el, log bytes.Buffer
+	encodeElement(&el, "key", newElement([]byte("data"), time.Now(), 0, time.Hour))
+	log.WriteString(logMagic + "\x01")
+	appendRecord(&log, opSet, el.Bytes()[:el.Len()-2])
+	path := filepath.Join(dir, logFile)
+	assert.Nil(t, ioutil.WriteFile(path, log.Bytes(), 0644))
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncAlways))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+	defer conn.Close()
+	b, err := conn.Read([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), b)
+
+	// the log is rewritten in the current version before anything is appended to it
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(logVersion), data[len(logMagic)])
+}
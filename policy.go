@@ -0,0 +1,209 @@
+package memorystorecache
+
+import (
+	"container/list"
+)
+
+// Policy decides which key a shard evicts when it is over its memory budget
+// each shard owns its own Policy and calls it while holding the shard's policy lock,
+// so implementations do not need to be safe for concurrent use
+type Policy interface {
+	// Add records a key that was inserted into the shard
+	Add(key string)
+	// Access records a read hit or an overwrite of a key
+	Access(key string)
+	// Remove forgets a key that left the shard for any reason other than Evict
+	Remove(key string)
+	// Evict picks the next key to evict and forgets it
+	// ok is false when the policy is not tracking any keys
+	Evict() (key string, ok bool)
+}
+
+// PolicyFactory creates a Policy for a single shard
+type PolicyFactory func() Policy
+
+// lru evicts the least recently used key
+type lru struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewLRU creates a least recently used eviction policy
+func NewLRU() Policy {
+	return &lru{ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (p *lru) Add(key string) {
+	if e, ok := p.items[key]; ok {
+		p.ll.MoveToFront(e)
+		return
+	}
+	p.items[key] = p.ll.PushFront(key)
+}
+
+func (p *lru) Access(key string) {
+	if e, ok := p.items[key]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lru) Remove(key string) {
+	if e, ok := p.items[key]; ok {
+		p.ll.Remove(e)
+		delete(p.items, key)
+	}
+}
+
+func (p *lru) Evict() (string, bool) {
+	e := p.ll.Back()
+	if e == nil {
+		return "", false
+	}
+	key := p.ll.Remove(e).(string)
+	delete(p.items, key)
+	return key, true
+}
+
+// lfu evicts the least frequently used key, breaking ties by recency
+// frequencies are kept in an ordered list of buckets so every operation is O(1)
+type lfu struct {
+	buckets *list.List // of *lfuBucket in ascending frequency order
+	items   map[string]*lfuItem
+}
+
+type lfuBucket struct {
+	freq uint64
+	keys *list.List // oldest key at the front
+}
+
+type lfuItem struct {
+	bucket *list.Element
+	key    *list.Element
+}
+
+// NewLFU creates a least frequently used eviction policy
+func NewLFU() Policy {
+	return &lfu{buckets: list.New(), items: map[string]*lfuItem{}}
+}
+
+func (p *lfu) Add(key string) {
+	if _, ok := p.items[key]; ok {
+		p.Access(key)
+		return
+	}
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = p.buckets.PushFront(&lfuBucket{freq: 1, keys: list.New()})
+	}
+	p.items[key] = &lfuItem{
+		bucket: front,
+		key:    front.Value.(*lfuBucket).keys.PushBack(key),
+	}
+}
+
+func (p *lfu) Access(key string) {
+	it, ok := p.items[key]
+	if !ok {
+		return
+	}
+	cur := it.bucket.Value.(*lfuBucket)
+	next := it.bucket.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != cur.freq+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{freq: cur.freq + 1, keys: list.New()}, it.bucket)
+	}
+	cur.keys.Remove(it.key)
+	if cur.keys.Len() == 0 {
+		p.buckets.Remove(it.bucket)
+	}
+	it.bucket = next
+	it.key = next.Value.(*lfuBucket).keys.PushBack(key)
+}
+
+func (p *lfu) Remove(key string) {
+	it, ok := p.items[key]
+	if !ok {
+		return
+	}
+	b := it.bucket.Value.(*lfuBucket)
+	b.keys.Remove(it.key)
+	if b.keys.Len() == 0 {
+		p.buckets.Remove(it.bucket)
+	}
+	delete(p.items, key)
+}
+
+func (p *lfu) Evict() (string, bool) {
+	front := p.buckets.Front()
+	if front == nil {
+		return "", false
+	}
+	key := front.Value.(*lfuBucket).keys.Front().Value.(string)
+	p.Remove(key)
+	return key, true
+}
+
+// sieve implements the SIEVE algorithm, a scan resistant policy
+// keys are kept in insertion order and a hand sweeps from oldest to newest,
+// sparing (and clearing) keys that were accessed since the hand last passed them
+type sieve struct {
+	ll    *list.List // newest key at the front
+	items map[string]*list.Element
+	hand  *list.Element
+}
+
+type sieveNode struct {
+	key     string
+	visited bool
+}
+
+// NewSIEVE creates a SIEVE eviction policy
+func NewSIEVE() Policy {
+	return &sieve{ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (p *sieve) Add(key string) {
+	if e, ok := p.items[key]; ok {
+		e.Value.(*sieveNode).visited = true
+		return
+	}
+	p.items[key] = p.ll.PushFront(&sieveNode{key: key})
+}
+
+func (p *sieve) Access(key string) {
+	if e, ok := p.items[key]; ok {
+		e.Value.(*sieveNode).visited = true
+	}
+}
+
+func (p *sieve) Remove(key string) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+	if p.hand == e {
+		p.hand = e.Prev()
+	}
+	p.ll.Remove(e)
+	delete(p.items, key)
+}
+
+func (p *sieve) Evict() (string, bool) {
+	if p.ll.Len() == 0 {
+		return "", false
+	}
+	e := p.hand
+	if e == nil {
+		e = p.ll.Back()
+	}
+	for e.Value.(*sieveNode).visited {
+		e.Value.(*sieveNode).visited = false
+		if e = e.Prev(); e == nil {
+			e = p.ll.Back()
+		}
+	}
+	key := e.Value.(*sieveNode).key
+	p.hand = e.Prev()
+	p.ll.Remove(e)
+	delete(p.items, key)
+	return key, true
+}
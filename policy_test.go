@@ -0,0 +1,95 @@
+package memorystorecache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func evictAll(p Policy) []string {
+	var keys []string
+	for {
+		k, ok := p.Evict()
+		if !ok {
+			return keys
+		}
+		keys = append(keys, k)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	p := NewLRU()
+	p.Add("a")
+	p.Add("b")
+	p.Add("c")
+	p.Access("a")
+	p.Remove("b")
+
+	assert.Equal(t, []string{"c", "a"}, evictAll(p))
+}
+
+func TestLFU(t *testing.T) {
+	p := NewLFU()
+	p.Add("a")
+	p.Add("b")
+	p.Add("c")
+	p.Add("d")
+	p.Access("a")
+	p.Access("a")
+	p.Access("c")
+	p.Remove("d")
+
+	// b has the fewest accesses, then c, then a
+	assert.Equal(t, []string{"b", "c", "a"}, evictAll(p))
+}
+
+func TestLFUTiesEvictOldest(t *testing.T) {
+	p := NewLFU()
+	p.Add("a")
+	p.Add("b")
+	p.Access("a")
+	p.Access("b")
+
+	assert.Equal(t, []string{"a", "b"}, evictAll(p))
+}
+
+func TestSIEVE(t *testing.T) {
+	p := NewSIEVE()
+	p.Add("a")
+	p.Add("b")
+	p.Add("c")
+	p.Access("a")
+
+	// a was visited so the hand spares it once
+	k, ok := p.Evict()
+	assert.True(t, ok)
+	assert.Equal(t, "b", k)
+
+	// the hand resumes from where it stopped, moving towards newer keys
+	p.Add("d")
+	p.Remove("c")
+	assert.Equal(t, []string{"d", "a"}, evictAll(p))
+}
+
+func TestSIEVEScanResistance(t *testing.T) {
+	p := NewSIEVE()
+	p.Add("hot")
+	p.Access("hot")
+
+	// a scan of one-hit keys should not push out the hot key
+	for i := 0; i < 10; i++ {
+		p.Add(fmt.Sprintf("scan-%d", i))
+		k, ok := p.Evict()
+		assert.True(t, ok)
+		assert.NotEqual(t, "hot", k)
+		p.Access("hot")
+	}
+}
+
+func TestPolicyEmpty(t *testing.T) {
+	for _, f := range []PolicyFactory{NewLRU, NewLFU, NewSIEVE} {
+		_, ok := f().Evict()
+		assert.False(t, ok)
+	}
+}
@@ -0,0 +1,126 @@
+package memorystorecache
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// WithRefresher registers the loader used to refresh stale keys in the background
+// keys are refreshed once they pass the soft TTL given to WriteSoftTTL,
+// or early when WithEarlyRefresh is set
+func WithRefresher(fn func(key []byte) ([]byte, error)) Option {
+	return func(c *Cache) error {
+		if fn == nil {
+			return errors.New("refresher must not be nil")
+		}
+		c.refresher = fn
+		return nil
+	}
+}
+
+// WithEarlyRefresh enables probabilistic early refreshes (XFetch)
+// a read refreshes a key before it expires with a probability that grows as expiry nears,
+// scaled by how long the key took to load; beta > 1 favors earlier refreshes
+// it only applies to keys loaded with GetOrLoad or a refresher
+func WithEarlyRefresh(beta float64) Option {
+	return func(c *Cache) error {
+		if beta <= 0 {
+			return errors.New("early refresh beta must be positive")
+		}
+		c.earlyBeta = beta
+		return nil
+	}
+}
+
+// WriteSoftTTL writes data that goes stale after softTTL and expires after hardTTL
+// reads between the two return the stale value and refresh the key in the background
+// with the refresher registered through WithRefresher
+// a hardTTL of 0 does not expire the key
+func (c *Conn) WriteSoftTTL(k, v []byte, softTTL, hardTTL time.Duration) error {
+	if softTTL <= 0 || (hardTTL != 0 && softTTL > hardTTL) {
+		return errors.New("soft TTL must be positive and no longer than the hard TTL")
+	}
+	return c.writeElement(string(k), newElement(v, c.now(), softTTL, hardTTL))
+}
+
+// maybeRefresh starts a background refresh of a key that was read at t, if it is due
+func (c *Conn) maybeRefresh(key string, el cacheElement, t time.Time) {
+	if c.refresher == nil {
+		return
+	}
+
+	switch {
+	case !el.staleAt.IsZero() && !t.Before(el.staleAt):
+		atomic.AddUint64(&c.stats.staleHits, 1)
+	case c.refreshEarly(el, t):
+		atomic.AddUint64(&c.stats.earlyRefreshes, 1)
+	default:
+		return
+	}
+
+	if c.loads.beginRefresh(key) {
+		go c.refresh(key, el)
+	}
+}
+
+// refreshEarly decides whether to refresh an unexpired key at t using XFetch
+// see "Optimal Probabilistic Cache Stampede Prevention" (Vattani et al.)
+func (c *Conn) refreshEarly(el cacheElement, t time.Time) bool {
+	expiry := el.staleAt
+	if expiry.IsZero() {
+		expiry = el.expiresAt
+	}
+	if c.earlyBeta <= 0 || el.delta <= 0 || expiry.IsZero() {
+		return false
+	}
+
+	// 1 - c.random() is in (0, 1], avoiding log(0)
+	gap := -float64(el.delta) * c.earlyBeta * math.Log(1-c.random())
+	return !t.Add(time.Duration(gap)).Before(expiry)
+}
+
+// refresh reloads a key with the refresher and rewrites it with its original TTLs
+// the result is dropped if the key was written or deleted while it loaded, as it would be older than the key
+func (c *Conn) refresh(key string, el cacheElement) {
+	defer c.loads.endRefresh(key)
+
+	start := c.clock.Now()
+	v, err := c.refresher([]byte(key))
+	if err != nil {
+		atomic.AddUint64(&c.stats.refreshErrors, 1)
+		return
+	}
+	atomic.AddUint64(&c.stats.refreshes, 1)
+
+	next := newElement(v, c.now(), el.softTTL, el.ttl)
+	next.delta = c.clock.Now().Sub(start)
+	next.fill = true
+	c.writeElementIf(key, next, func(cur cacheElement, exists bool) error {
+		if !exists || cur.version != el.version {
+			return ErrVersionMismatch
+		}
+		return nil
+	})
+}
+
+// beginRefresh marks a key as refreshing, returning false if it already is
+func (g *loadGroup) beginRefresh(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.refreshing == nil {
+		g.refreshing = map[string]struct{}{}
+	}
+	if _, ok := g.refreshing[key]; ok {
+		return false
+	}
+	g.refreshing[key] = struct{}{}
+	return true
+}
+
+func (g *loadGroup) endRefresh(key string) {
+	g.mu.Lock()
+	delete(g.refreshing, key)
+	g.mu.Unlock()
+}
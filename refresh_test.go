@@ -0,0 +1,195 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSoftTTL(t *testing.T) {
+	refreshed := make(chan struct{})
+	refresher := func(key []byte) ([]byte, error) {
+		defer close(refreshed)
+		assert.Equal(t, []byte("key"), key)
+		return []byte{2}, nil
+	}
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, time.Second, WithRefresher(refresher), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	err = conn.WriteSoftTTL(key, []byte{1}, 100*time.Millisecond, time.Minute)
+	assert.Nil(t, err)
+
+	// fresh
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+
+	// stale values are served while the key refreshes
+	clk.Advance(100 * time.Millisecond)
+	b, err = conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+
+	<-refreshed
+	waitFor(t, func() bool {
+		b, err := conn.Read(key)
+		return err == nil && b[0] == 2
+	})
+
+	// the refreshed key keeps its soft and hard TTLs
+	ttl, err := conn.RemainingTTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Second)
+
+	s, err := conn.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["StaleHits"])
+	assert.Equal(t, uint64(1), s["Refreshes"])
+}
+
+func TestWriteSoftTTLRefreshesOnce(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	refresher := func(key []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, errors.New("origin down")
+	}
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, time.Second, WithRefresher(refresher), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	err = conn.WriteSoftTTL(key, []byte{1}, time.Millisecond, time.Minute)
+	assert.Nil(t, err)
+	clk.Advance(time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		b, err := conn.Read(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{1}, b)
+	}
+	close(release)
+
+	waitFor(t, func() bool {
+		s, _ := conn.Stats()
+		return s["RefreshErrors"] == uint64(1)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// a failed refresh leaves the stale value in place
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+}
+
+func TestRefreshDoesNotUndoWrites(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	refresher := func(key []byte) ([]byte, error) {
+		started <- struct{}{}
+		<-release
+		return []byte("refreshed"), nil
+	}
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, 0, WithRefresher(refresher), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	refreshing := func() bool {
+		conn.loads.mu.Lock()
+		defer conn.loads.mu.Unlock()
+		return len(conn.loads.refreshing) > 0
+	}
+	for _, tc := range []struct {
+		change func(k []byte)
+		want   []byte // nil for a missing key
+	}{
+		{func(k []byte) { assert.Nil(t, conn.Delete(k)) }, nil},
+		{func(k []byte) { assert.Nil(t, conn.Write(k, []byte("newer"))) }, []byte("newer")},
+	} {
+		key := []byte("key")
+		assert.Nil(t, conn.WriteSoftTTL(key, []byte("stale"), time.Millisecond, time.Minute))
+		clk.Advance(time.Millisecond)
+		_, err := conn.Read(key)
+		assert.Nil(t, err)
+		<-started
+
+		// a key deleted or written while it refreshes keeps that change
+		tc.change(key)
+		release <- struct{}{}
+		waitFor(t, func() bool { return !refreshing() })
+		b, err := conn.Read(key)
+		if tc.want == nil {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Equal(t, tc.want, b)
+		}
+	}
+	assert.Equal(t, uint64(2), conn.TypedStats().Refreshes)
+}
+
+func TestWriteSoftTTLValidation(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	assert.NotNil(t, conn.WriteSoftTTL(key, []byte{1}, 0, time.Minute))
+	assert.NotNil(t, conn.WriteSoftTTL(key, []byte{1}, time.Hour, time.Minute))
+	assert.Nil(t, conn.WriteSoftTTL(key, []byte{1}, time.Hour, 0))
+
+	_, err = NewCache(time.Second, time.Second, WithRefresher(nil))
+	assert.EqualError(t, err, "refresher must not be nil")
+	_, err = NewCache(time.Second, time.Second, WithEarlyRefresh(0))
+	assert.EqualError(t, err, "early refresh beta must be positive")
+}
+
+func TestRefreshEarly(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second, WithEarlyRefresh(1))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	now := time.Now().UTC()
+	el := newElement([]byte{1}, now, 0, time.Hour)
+	el.delta = time.Minute
+
+	// with a draw of 0.5 a key that took a minute to load is refreshed within ln 2 minutes of expiry
+	conn.random = func() float64 { return 0.5 }
+	assert.False(t, conn.refreshEarly(el, now))
+	assert.False(t, conn.refreshEarly(el, now.Add(59*time.Minute)))
+	assert.True(t, conn.refreshEarly(el, now.Add(59*time.Minute+30*time.Second)))
+
+	// the window grows with the load time
+	el.delta = 2 * time.Minute
+	assert.True(t, conn.refreshEarly(el, now.Add(59*time.Minute)))
+
+	// a draw of 0 only refreshes once the key expires
+	conn.random = func() float64 { return 0 }
+	assert.False(t, conn.refreshEarly(el, now.Add(time.Hour-time.Millisecond)))
+	assert.True(t, conn.refreshEarly(el, now.Add(time.Hour)))
+
+	// keys without a load time or expiry are left alone
+	el.delta = 0
+	assert.False(t, conn.refreshEarly(el, now.Add(59*time.Minute)))
+	el = newElement([]byte{1}, now, 0, 0)
+	el.delta = time.Hour
+	assert.False(t, conn.refreshEarly(el, now))
+}
@@ -0,0 +1,317 @@
+// Package httpapi exposes a memory store connection over HTTP, for inspecting and managing a running cache
+//
+//	GET    /keys/{key}  read a key, its remaining TTL is in the X-TTL header
+//	PUT    /keys/{key}  write the request body, with the TTL from the X-TTL header
+//	DELETE /keys/{key}  delete a key
+//	GET    /keys        list keys as JSON, filtered by the prefix query parameter,
+//	                    or a page of keys when given a cursor, with optional match and count parameters
+//	GET    /stats       stats as JSON
+//	POST   /flush       remove every key
+//	GET    /snapshot    download a snapshot
+//	PUT    /snapshot    restore a snapshot on top of the current keys
+//
+// keys are path escaped, so a key containing a slash is requested as /keys/a%2Fb
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+// TTLHeader holds a key's TTL, as a duration such as 90s or a number of seconds
+// a TTL of 0 never expires
+const TTLHeader = "X-TTL"
+
+// DefaultMaxValueBytes is the largest value accepted by PUT when Handler.MaxValueBytes is not set
+const DefaultMaxValueBytes = 64 << 20
+
+// DefaultMaxSnapshotBytes is the largest snapshot accepted by PUT /snapshot when Handler.MaxSnapshotBytes is not set
+const DefaultMaxSnapshotBytes = 1 << 30
+
+// Handler serves a memory store connection over HTTP
+// mount it under a prefix with http.StripPrefix
+type Handler struct {
+	conn *memorystorecache.Conn
+
+	// MaxValueBytes bounds the body of a PUT, DefaultMaxValueBytes if 0
+	MaxValueBytes int64
+	// MaxSnapshotBytes bounds the body of a snapshot restore, DefaultMaxSnapshotBytes if 0
+	MaxSnapshotBytes int64
+}
+
+// NewHandler creates a Handler for conn
+func NewHandler(conn *memorystorecache.Conn) *Handler {
+	return &Handler{conn: conn}
+}
+
+// ServeHTTP routes a request to its endpoint
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, "/keys/"):
+		key, err := url.PathUnescape(strings.TrimPrefix(path, "/keys/"))
+		if err != nil || key == "" {
+			writeError(w, http.StatusBadRequest, "invalid key")
+			return
+		}
+		h.serveKey(w, r, []byte(key))
+	case path == "/keys":
+		if allow(w, r, http.MethodGet) {
+			h.listKeys(w, r)
+		}
+	case path == "/stats":
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, h.conn.TypedStats())
+		}
+	case path == "/flush":
+		if allow(w, r, http.MethodPost) {
+			h.flush(w, r)
+		}
+	case path == "/snapshot":
+		h.serveSnapshot(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request, key []byte) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, key)
+	case http.MethodPut:
+		h.put(w, r, key)
+	case http.MethodDelete:
+		h.delete(w, r, key)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, key []byte) {
+	v, err := h.conn.Read(key)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if ttl, err := h.conn.RemainingTTL(key); err == nil {
+		w.Header().Set(TTLHeader, ttl.String())
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(v)))
+	w.Write(v)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, key []byte) {
+	ttl := h.conn.TTL
+	if v := r.Header.Get(TTLHeader); v != "" {
+		var err error
+		if ttl, err = parseTTL(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid "+TTLHeader+" header")
+			return
+		}
+	}
+
+	max := h.MaxValueBytes
+	if max <= 0 {
+		max = DefaultMaxValueBytes
+	}
+	if r.ContentLength > max {
+		writeError(w, http.StatusRequestEntityTooLarge, "value too large")
+		return
+	}
+	v, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "value too large")
+		return
+	}
+
+	err = h.conn.WriteTTL(key, v, ttl)
+	if err == memorystorecache.ErrValueTooLarge {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, key []byte) {
+	if err := h.conn.Delete(key); err != nil {
+		if err == memorystorecache.ErrKeyNotFound {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keyList is the body of GET /keys
+type keyList struct {
+	Keys []string `json:"keys"`
+	// Cursor is the cursor of the next page when paging, 0 after the last page
+	Cursor string `json:"cursor,omitempty"`
+}
+
+func (h *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if _, ok := q["cursor"]; ok {
+		h.scanKeys(w, q)
+		return
+	}
+
+	keys := h.conn.Keys([]byte(q.Get("prefix")))
+	list := keyList{Keys: make([]string, len(keys))}
+	for i, k := range keys {
+		list.Keys[i] = string(k)
+	}
+	sort.Strings(list.Keys)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// scanKeys serves a page of keys
+func (h *Handler) scanKeys(w http.ResponseWriter, q url.Values) {
+	cursor, err := strconv.ParseUint(q.Get("cursor"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid cursor")
+		return
+	}
+	count := 100
+	if v := q.Get("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil || count < 1 {
+			writeError(w, http.StatusBadRequest, "invalid count")
+			return
+		}
+	}
+	var match []byte
+	if v, ok := q["match"]; ok {
+		match = []byte(v[0])
+	}
+
+	keys, next := h.conn.Scan(cursor, match, count)
+	list := keyList{Keys: make([]string, len(keys)), Cursor: strconv.FormatUint(next, 10)}
+	for i, k := range keys {
+		list.Keys[i] = string(k)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *Handler) flush(w http.ResponseWriter, r *http.Request) {
+	if err := h.conn.Flush(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="memorystore.snapshot"`)
+		// the status is sent with the first write, so a failure midway shows up as a truncated,
+		// and so invalid, snapshot
+		h.conn.Snapshot(w)
+	case http.MethodPut, http.MethodPost:
+		max := h.MaxSnapshotBytes
+		if max <= 0 {
+			max = DefaultMaxSnapshotBytes
+		}
+		if r.ContentLength > max {
+			writeError(w, http.StatusRequestEntityTooLarge, "snapshot too large")
+			return
+		}
+		body := &errReader{r: http.MaxBytesReader(w, r.Body, max)}
+		err := h.conn.Restore(body)
+		if body.err != nil {
+			// Restore reports a body cut off at the limit as an invalid snapshot
+			writeError(w, http.StatusRequestEntityTooLarge, "snapshot too large")
+			return
+		}
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case memorystorecache.ErrInvalidSnapshot, memorystorecache.ErrSnapshotVersion, memorystorecache.ErrSnapshotChecksum:
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPost)
+	}
+}
+
+// errReader remembers the first error other than io.EOF returned by r
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// parseTTL parses a TTL header, either a duration or a number of seconds
+func parseTTL(v string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		// a number of seconds that does not fit in a Duration would wrap around
+		if n < 0 || n > math.MaxInt64/int64(time.Second) {
+			return 0, strconv.ErrRange
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, strconv.ErrRange
+	}
+	return d, nil
+}
+
+// allow reports whether the request uses method, answering it with 405 if not
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	methodNotAllowed(w, method)
+	return false
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// errorBody is the body of every error response
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorBody{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
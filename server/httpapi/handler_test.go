@@ -0,0 +1,235 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+func newTestHandler(t *testing.T, opts ...memorystorecache.Option) (*memorystorecache.Conn, *Handler) {
+	c, err := memorystorecache.NewCache(time.Minute, 0, opts...)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	return conn, NewHandler(conn)
+}
+
+func serve(h http.Handler, method, target string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, bytes.NewReader(body))
+	for k, vs := range header {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestKeys(t *testing.T) {
+	clk := memorystorecache.NewFakeClock(time.Now())
+	conn, h := newTestHandler(t, memorystorecache.WithClock(clk))
+	defer conn.Close()
+
+	w := serve(h, "GET", "/keys/a%2Fb", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "{\"error\":\"Key not found\"}\n", w.Body.String())
+
+	w = serve(h, "PUT", "/keys/a%2Fb", []byte("data"), http.Header{TTLHeader: {"90s"}})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	b, err := conn.Read([]byte("a/b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), b)
+
+	w = serve(h, "GET", "/keys/a%2Fb", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "data", w.Body.String())
+	assert.Equal(t, "1m30s", w.Header().Get(TTLHeader))
+
+	w = serve(h, "DELETE", "/keys/a%2Fb", nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(h, "DELETE", "/keys/a%2Fb", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(h, "POST", "/keys/a", nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD, PUT, DELETE", w.Header().Get("Allow"))
+}
+
+func TestPutTTL(t *testing.T) {
+	clk := memorystorecache.NewFakeClock(time.Now())
+	conn, h := newTestHandler(t, memorystorecache.WithClock(clk))
+	defer conn.Close()
+
+	serve(h, "PUT", "/keys/default", []byte("v"), nil)
+	ttl, err := conn.RemainingTTL([]byte("default"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	serve(h, "PUT", "/keys/seconds", []byte("v"), http.Header{TTLHeader: {"10"}})
+	ttl, err = conn.RemainingTTL([]byte("seconds"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Second, ttl)
+
+	serve(h, "PUT", "/keys/forever", []byte("v"), http.Header{TTLHeader: {"0"}})
+	ttl, err = conn.RemainingTTL([]byte("forever"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	for _, bad := range []string{"-1s", "-1", "9223372037", "9223372036854775807"} {
+		w := serve(h, "PUT", "/keys/bad", []byte("v"), http.Header{TTLHeader: {bad}})
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+		assert.False(t, conn.Exists([]byte("bad")), bad)
+	}
+}
+
+func TestPutTooLarge(t *testing.T) {
+	conn, h := newTestHandler(t)
+	defer conn.Close()
+	h.MaxValueBytes = 4
+
+	w := serve(h, "PUT", "/keys/k", []byte("12345"), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.False(t, conn.Exists([]byte("k")))
+}
+
+func TestListKeys(t *testing.T) {
+	conn, h := newTestHandler(t)
+	defer conn.Close()
+
+	for _, k := range []string{"user:2", "user:1", "episode:1"} {
+		assert.Nil(t, conn.Write([]byte(k), []byte("v")))
+	}
+
+	w := serve(h, "GET", "/keys?prefix=user:", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list keyList
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, []string{"user:1", "user:2"}, list.Keys)
+
+	w = serve(h, "GET", "/keys", nil, nil)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Keys, 3)
+}
+
+func TestScanKeys(t *testing.T) {
+	conn, h := newTestHandler(t, memorystorecache.WithShards(4))
+	defer conn.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, conn.Write([]byte("user:"+strconv.Itoa(i)), []byte("v")))
+	}
+	assert.Nil(t, conn.Write([]byte("episode:1"), []byte("v")))
+
+	var keys []string
+	cursor := "0"
+	for {
+		w := serve(h, "GET", "/keys?match=user:*&count=5&cursor="+cursor, nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var list keyList
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+		keys = append(keys, list.Keys...)
+		cursor = list.Cursor
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Len(t, keys, 20)
+
+	w := serve(h, "GET", "/keys?cursor=x", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestStatsAndFlush(t *testing.T) {
+	conn, h := newTestHandler(t)
+	defer conn.Close()
+
+	assert.Nil(t, conn.Write([]byte("k"), []byte("v")))
+	w := serve(h, "GET", "/stats", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var st memorystorecache.ConnStats
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &st))
+	assert.Equal(t, uint64(1), st.KeyCount)
+
+	w = serve(h, "GET", "/flush", nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = serve(h, "POST", "/flush", nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, conn.Exists([]byte("k")))
+}
+
+func TestSnapshot(t *testing.T) {
+	conn, h := newTestHandler(t)
+	defer conn.Close()
+	assert.Nil(t, conn.Write([]byte("k"), []byte("v")))
+
+	w := serve(h, "GET", "/snapshot", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	snap := w.Body.Bytes()
+
+	other, oh := newTestHandler(t)
+	defer other.Close()
+	w = serve(oh, "PUT", "/snapshot", []byte("nope"), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(oh, "PUT", "/snapshot", snap, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	b, err := other.Read([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), b)
+}
+
+func TestSnapshotLimits(t *testing.T) {
+	conn, h := newTestHandler(t)
+	defer conn.Close()
+	assert.Nil(t, conn.Write([]byte("k"), []byte("value")))
+	snap := serve(h, "GET", "/snapshot", nil, nil).Body.Bytes()
+
+	// a corrupt length is rejected without allocating it
+	w := serve(h, "PUT", "/snapshot", []byte("MSSN\x02\x01\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	h.MaxSnapshotBytes = int64(len(snap) - 1)
+	w = serve(h, "PUT", "/snapshot", snap, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// a body without a length is cut off at the limit
+	r := httptest.NewRequest("PUT", "/snapshot", bytes.NewReader(snap))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestMount(t *testing.T) {
+	conn, h := newTestHandler(t)
+	defer conn.Close()
+	assert.Nil(t, conn.Write([]byte("k"), []byte("v")))
+
+	mux := http.NewServeMux()
+	mux.Handle("/cache/", http.StripPrefix("/cache", h))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/cache/keys/k")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "v", string(b))
+
+	resp, err = http.Get(srv.URL + "/cache/nope")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"))
+}
@@ -0,0 +1,104 @@
+// Package internal holds the connection handling, data reads and stats formatting shared by the resp and memcache servers
+package internal
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// Listeners tracks a server's listeners and clients so Close can stop them
+// the zero value is ready to use
+type Listeners struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	clients   map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// Serve accepts clients on ln, handling each with serve on its own goroutine,
+// until the listener fails or Close is called, returning errClosed after Close
+// clients are closed once serve returns
+func (l *Listeners) Serve(ln net.Listener, errClosed error, serve func(net.Conn)) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		ln.Close()
+		return errClosed
+	}
+	if l.listeners == nil {
+		l.listeners = map[net.Listener]struct{}{}
+		l.clients = map[net.Conn]struct{}{}
+	}
+	l.listeners[ln] = struct{}{}
+	l.mu.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			delete(l.listeners, ln)
+			l.mu.Unlock()
+			if closed {
+				return errClosed
+			}
+			return err
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			nc.Close()
+			return errClosed
+		}
+		l.clients[nc] = struct{}{}
+		l.wg.Add(1)
+		l.mu.Unlock()
+
+		go l.serveClient(nc, serve)
+	}
+}
+
+func (l *Listeners) serveClient(nc net.Conn, serve func(net.Conn)) {
+	defer func() {
+		nc.Close()
+		l.mu.Lock()
+		delete(l.clients, nc)
+		l.mu.Unlock()
+		l.wg.Done()
+	}()
+	serve(nc)
+}
+
+// Close stops the listeners, disconnects every client and waits for their handlers to return
+func (l *Listeners) Close() {
+	l.mu.Lock()
+	l.closed = true
+	for ln := range l.listeners {
+		ln.Close()
+	}
+	for nc := range l.clients {
+		nc.Close()
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+}
+
+// Clients returns the number of connected clients
+func (l *Listeners) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.clients)
+}
+
+// FlushIdle flushes w once r holds no more pipelined commands,
+// so a pipeline is answered in one write
+func FlushIdle(r *bufio.Reader, w *bufio.Writer) error {
+	if r.Buffered() > 0 {
+		return nil
+	}
+	return w.Flush()
+}
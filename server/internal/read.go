@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"io"
+)
+
+// BlockChunk caps what ReadBlock allocates up front,
+// the rest is allocated as the data arrives rather than trusting the length the client sends
+const BlockChunk = 64 << 10
+
+// ReadBlock reads exactly n bytes into a new slice, since the cache keeps written values
+// it doubles the slice as data arrives, ending at exactly n bytes
+func ReadBlock(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, 0, minInt(n, BlockChunk))
+	for len(buf) < n {
+		if len(buf) == cap(buf) {
+			grown := make([]byte, len(buf), minInt(2*cap(buf), n))
+			copy(grown, buf)
+			buf = grown
+		}
+		m, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+m]
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadBlock(t *testing.T) {
+	v := bytes.Repeat([]byte("v"), 3*BlockChunk+1)
+	b, err := ReadBlock(io.MultiReader(bytes.NewReader(v), strings.NewReader("rest")), len(v))
+	assert.Nil(t, err)
+	assert.Equal(t, v, b)
+	assert.Equal(t, len(v), cap(b))
+
+	b, err = ReadBlock(strings.NewReader(""), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, b)
+
+	// lengths the client does not follow with data are not allocated up front
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = ReadBlock(strings.NewReader("abc"), 64<<20)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	runtime.ReadMemStats(&after)
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 1<<20, "allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+}
@@ -0,0 +1,43 @@
+package internal
+
+import "sort"
+
+// Stat is one of a connection's stats, named in snake case
+type Stat struct {
+	Name  string
+	Value interface{}
+}
+
+// Stats returns a connection's stats sorted by name, without the per shard stats
+// which do not fit on a line
+func Stats(stats map[string]interface{}) []Stat {
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		if k != "Shards" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	out := make([]Stat, len(keys))
+	for i, k := range keys {
+		out[i] = Stat{Name: toSnakeCase(k), Value: stats[k]}
+	}
+	return out
+}
+
+// toSnakeCase turns a stats key like KeyCount into key_count
+func toSnakeCase(s string) string {
+	b := make([]byte, 0, len(s)+4)
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' {
+			if i > 0 {
+				b = append(b, '_')
+			}
+			ch += 'a' - 'A'
+		}
+		b = append(b, ch)
+	}
+	return string(b)
+}
@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	stats := map[string]interface{}{
+		"KeyCount": 2,
+		"Hits":     uint64(3),
+		"Shards":   []int{1, 1},
+	}
+	assert.Equal(t, []Stat{{"hits", uint64(3)}, {"key_count", 2}}, Stats(stats))
+}
@@ -0,0 +1,51 @@
+package memcache
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// relativeExptimeMax is the largest exptime treated as seconds from now, larger ones are unix times
+const relativeExptimeMax = 60 * 60 * 24 * 30
+
+var (
+	errNotFound   = errors.New("not found")
+	errNotStored  = errors.New("not stored")
+	errExists     = errors.New("exists")
+	errNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+)
+
+// item is a value with its memcached metadata
+// flags are stored alongside the value with memorystorecache.WriteFlags, and CAS tokens are the versions
+// the cache gives every write, so values are shared as is with other clients of the connection
+type item struct {
+	flags uint32
+	cas   uint64
+	data  []byte
+}
+
+// exptimeTTL converts a memcached expiration time into a TTL, with absolute times relative to now
+// expired is true for times in the past, which memcached treats as an immediate expiry
+func exptimeTTL(exptime int64, now time.Time) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime < 0:
+		return 0, true
+	case exptime == 0:
+		return 0, false
+	case exptime > relativeExptimeMax:
+		ttl = time.Unix(exptime, 0).Sub(now)
+		return ttl, ttl <= 0
+	}
+	return time.Duration(exptime) * time.Second, false
+}
+
+// parseExptime parses an expiration time and converts it into a TTL, with absolute times relative to now
+func parseExptime(b []byte, now time.Time) (ttl time.Duration, expired bool, err error) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	ttl, expired = exptimeTTL(n, now)
+	return ttl, expired, nil
+}
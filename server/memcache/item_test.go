@@ -0,0 +1,30 @@
+package memcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExptimeTTL(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	ttl, expired := exptimeTTL(0, now)
+	assert.Equal(t, time.Duration(0), ttl)
+	assert.False(t, expired)
+
+	ttl, expired = exptimeTTL(10, now)
+	assert.Equal(t, 10*time.Second, ttl)
+	assert.False(t, expired)
+
+	_, expired = exptimeTTL(-1, now)
+	assert.True(t, expired)
+
+	ttl, expired = exptimeTTL(now.Add(time.Hour).Unix(), now)
+	assert.Equal(t, time.Hour, ttl)
+	assert.False(t, expired)
+
+	_, expired = exptimeTTL(relativeExptimeMax+1, now)
+	assert.True(t, expired)
+}
@@ -0,0 +1,340 @@
+package memcache
+
+import (
+	"strconv"
+	"time"
+)
+
+// metaFlags are the flags of a meta command, keyed by flag character
+type metaFlags map[byte][]byte
+
+// parseMetaFlags parses flag tokens, allowing only the characters in allowed
+func parseMetaFlags(args [][]byte, allowed string) (metaFlags, bool) {
+	f := metaFlags{}
+	for _, a := range args {
+		if !containsByte(allowed, a[0]) {
+			return nil, false
+		}
+		f[a[0]] = a[1:]
+	}
+	return f, true
+}
+
+func containsByte(s string, b byte) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == b {
+			return true
+		}
+	}
+	return false
+}
+
+func (f metaFlags) has(b byte) bool {
+	_, ok := f[b]
+	return ok
+}
+
+// uint parses the numeric token of a flag, def is returned if the flag is absent
+func (f metaFlags) uint(b byte, def uint64) (uint64, bool) {
+	v, ok := f[b]
+	if !ok {
+		return def, true
+	}
+	n, err := strconv.ParseUint(string(v), 10, 64)
+	return n, err == nil
+}
+
+// ttl parses the TTL token of a flag, with absolute times relative to now
+func (f metaFlags) ttl(b byte, now time.Time) (ttl time.Duration, expired bool, ok bool) {
+	ttl, expired, err := parseExptime(f[b], now)
+	return ttl, expired, err == nil
+}
+
+// metaReply collects the return flags of a meta command
+type metaReply struct {
+	buf []byte
+}
+
+func (r *metaReply) add(flag byte, v string) {
+	r.buf = append(r.buf, ' ', flag)
+	r.buf = append(r.buf, v...)
+}
+
+// opaque echoes the O and k flags, which every meta command returns
+func (r *metaReply) opaque(f metaFlags, key []byte) {
+	if f.has('k') {
+		r.add('k', string(key))
+	}
+	if v, ok := f['O']; ok {
+		r.add('O', string(v))
+	}
+}
+
+// ttlToken formats a key's remaining TTL for the t flag, -1 if it never expires
+func (s *Server) ttlToken(key []byte) string {
+	ttl := s.remainingTTL(key)
+	if ttl == 0 {
+		return "-1"
+	}
+	return strconv.FormatInt(int64((ttl+time.Second/2)/time.Second), 10)
+}
+
+// writeMeta writes a meta response line
+func (c *client) writeMeta(code string, r metaReply) {
+	c.w.WriteString(code)
+	c.w.Write(r.buf)
+	c.w.WriteString("\r\n")
+}
+
+// metaGet implements mg <key> <flags>*
+func (s *Server) metaGet(c *client, args [][]byte) bool {
+	if len(args) == 0 || !validKey(args[0]) {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+	key := args[0]
+	f, ok := parseMetaFlags(args[1:], "vfcstkOqT")
+	if !ok {
+		c.w.WriteString("CLIENT_ERROR invalid flag\r\n")
+		return true
+	}
+
+	if f.has('T') {
+		ttl, expired, ok := f.ttl('T', s.conn.Now())
+		if !ok {
+			c.w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+			return true
+		}
+		s.touch(key, ttl, expired)
+	}
+	it, found := s.get(key)
+	if !found {
+		if !f.has('q') {
+			c.w.WriteString("EN\r\n")
+		}
+		return true
+	}
+
+	var r metaReply
+	for _, a := range args[1:] {
+		switch a[0] {
+		case 'f':
+			r.add('f', strconv.FormatUint(uint64(it.flags), 10))
+		case 'c':
+			r.add('c', strconv.FormatUint(it.cas, 10))
+		case 's':
+			r.add('s', strconv.Itoa(len(it.data)))
+		case 't':
+			r.add('t', s.ttlToken(key))
+		}
+	}
+	r.opaque(f, key)
+
+	if !f.has('v') {
+		c.writeMeta("HD", r)
+		return true
+	}
+	c.writeMeta("VA "+strconv.Itoa(len(it.data)), r)
+	c.w.Write(it.data)
+	c.w.WriteString("\r\n")
+	return true
+}
+
+// metaSet implements ms <key> <datalen> <flags>*
+func (s *Server) metaSet(c *client, args [][]byte) bool {
+	if len(args) < 2 || !validKey(args[0]) {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	key := []byte(string(args[0]))
+	n, err := strconv.Atoi(string(args[1]))
+	if err != nil || n < 0 {
+		c.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return false
+	}
+	f, fok := parseMetaFlags(args[2:], "FTCMqOkc")
+	var flags, cas uint64
+	var ttl time.Duration
+	var expired bool
+	mode := modeSet
+	if fok {
+		var ok1, ok2, ok3 bool
+		flags, ok1 = f.uint('F', 0)
+		cas, ok2 = f.uint('C', 0)
+		ttl, expired, ok3 = f.ttl('T', s.conn.Now())
+		if !f.has('T') {
+			ttl, expired, ok3 = 0, false, true
+		}
+		fok = ok1 && ok2 && ok3 && flags <= 1<<32-1
+		if cas != 0 {
+			mode = modeCAS
+		}
+		if m, ok := f['M']; ok && fok {
+			mode, fok = metaSetMode(m, mode)
+		}
+	}
+	// flags alias the read buffer, so copy the ones returned after reading the data
+	var r metaReply
+	if fok {
+		r.opaque(f, key)
+	}
+	quiet, returnCAS := f.has('q'), f.has('c')
+
+	data, err := c.readData(n)
+	if err == errTooLarge {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	if !fok {
+		c.w.WriteString("CLIENT_ERROR invalid flag\r\n")
+		return true
+	}
+
+	newCAS, err := s.store(mode, key, item{flags: uint32(flags), data: data}, ttl, expired, cas)
+	if err == nil && returnCAS {
+		r.add('c', strconv.FormatUint(newCAS, 10))
+	}
+	switch err {
+	case nil:
+		if !quiet {
+			c.writeMeta("HD", r)
+		}
+	case errNotStored:
+		c.writeMeta("NS", r)
+	case errExists:
+		c.writeMeta("EX", r)
+	case errNotFound:
+		c.writeMeta("NF", r)
+	default:
+		c.writeError(err)
+	}
+	return true
+}
+
+// metaSetMode parses the M flag of ms, a CAS compare stays a CAS in set mode
+func metaSetMode(m []byte, mode storeMode) (storeMode, bool) {
+	if len(m) != 1 {
+		return mode, false
+	}
+	switch m[0] {
+	case 'S', 's':
+		return mode, true
+	case 'E', 'e':
+		return modeAdd, true
+	case 'R', 'r':
+		return modeReplace, true
+	case 'A', 'a':
+		return modeAppend, true
+	case 'P', 'p':
+		return modePrepend, true
+	}
+	return mode, false
+}
+
+// metaDelete implements md <key> <flags>*
+func (s *Server) metaDelete(c *client, args [][]byte) bool {
+	if len(args) == 0 || !validKey(args[0]) {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+	key := args[0]
+	f, ok := parseMetaFlags(args[1:], "CqOk")
+	cas, cok := f.uint('C', 0)
+	if !ok || !cok {
+		c.w.WriteString("CLIENT_ERROR invalid flag\r\n")
+		return true
+	}
+
+	var r metaReply
+	r.opaque(f, key)
+	switch s.del(key, cas) {
+	case nil:
+		if !f.has('q') {
+			c.writeMeta("HD", r)
+		}
+	case errExists:
+		c.writeMeta("EX", r)
+	default:
+		if !f.has('q') {
+			c.writeMeta("NF", r)
+		}
+	}
+	return true
+}
+
+// metaArith implements ma <key> <flags>*
+func (s *Server) metaArith(c *client, args [][]byte) bool {
+	if len(args) == 0 || !validKey(args[0]) {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+	key := args[0]
+	f, ok := parseMetaFlags(args[1:], "NJDTMqOktcv")
+	if !ok {
+		c.w.WriteString("CLIENT_ERROR invalid flag\r\n")
+		return true
+	}
+	delta, dok := f.uint('D', 1)
+	initial, iok := f.uint('J', 0)
+	incr := true
+	if m, ok := f['M']; ok {
+		switch string(m) {
+		case "I", "i", "+":
+		case "D", "d", "-":
+			incr = false
+		default:
+			dok = false
+		}
+	}
+	var viv *vivify
+	if f.has('N') {
+		ttl, _, ok := f.ttl('N', s.conn.Now())
+		iok = iok && ok
+		viv = &vivify{initial: initial, ttl: ttl}
+	}
+	if !dok || !iok {
+		c.w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+		return true
+	}
+
+	var r metaReply
+	n, cas, err := s.arith(key, delta, incr, viv)
+	if err == nil && f.has('T') {
+		if ttl, expired, ok := f.ttl('T', s.conn.Now()); ok {
+			s.touch(key, ttl, expired)
+		}
+	}
+	switch err {
+	case nil:
+		for _, a := range args[1:] {
+			switch a[0] {
+			case 'c':
+				r.add('c', strconv.FormatUint(cas, 10))
+			case 't':
+				r.add('t', s.ttlToken(key))
+			}
+		}
+		r.opaque(f, key)
+		if f.has('v') {
+			v := strconv.FormatUint(n, 10)
+			c.writeMeta("VA "+strconv.Itoa(len(v)), r)
+			c.w.WriteString(v + "\r\n")
+			return true
+		}
+		if !f.has('q') {
+			c.writeMeta("HD", r)
+		}
+	case errNotFound:
+		r.opaque(f, key)
+		if !f.has('q') {
+			c.writeMeta("NF", r)
+		}
+	case errNonNumeric:
+		c.w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+	default:
+		c.writeError(err)
+	}
+	return true
+}
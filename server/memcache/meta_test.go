@@ -0,0 +1,77 @@
+package memcache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaGet(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "EN", cl.do("mg k v\r\n"))
+	assert.Equal(t, "HD", cl.do("ms k 5 F3\r\nhello\r\n"))
+
+	cl.send("mg k s f t v k Oabc\r\n")
+	assert.Equal(t, []string{"VA 5 s5 f3 t-1 kk Oabc", "hello"}, cl.lines(2))
+	assert.Equal(t, "HD f3", cl.do("mg k f\r\n"))
+
+	// quiet misses are only visible through the no-op that follows them
+	cl.send("mg missing v q\r\nmn\r\n")
+	assert.Equal(t, "MN", cl.lines(1)[0])
+	assert.Equal(t, "CLIENT_ERROR invalid flag", cl.do("mg k z\r\n"))
+}
+
+func TestMetaSetModes(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "NS", cl.do("ms k 1 MR\r\na\r\n"))
+	assert.Equal(t, "HD", cl.do("ms k 1 ME\r\na\r\n"))
+	assert.Equal(t, "NS", cl.do("ms k 1 ME\r\nb\r\n"))
+	assert.Equal(t, "HD", cl.do("ms k 1 MA\r\nb\r\n"))
+	cl.send("mg k v\r\n")
+	assert.Equal(t, []string{"VA 2", "ab"}, cl.lines(2))
+}
+
+func TestMetaCAS(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	reply := cl.do("ms k 1 c\r\na\r\n")
+	assert.True(t, strings.HasPrefix(reply, "HD c"))
+	token := strings.TrimPrefix(reply, "HD c")
+	assert.Equal(t, "HD c"+token, cl.do("mg k c\r\n"))
+
+	assert.Equal(t, "EX", cl.do("ms k 1 C1\r\nb\r\n"))
+	assert.Equal(t, "EX", cl.do("md k C1\r\n"))
+	assert.Equal(t, "HD", cl.do("ms k 1 C"+token+"\r\nb\r\n"))
+	cl.send("md k q\r\nmn\r\n")
+	assert.Equal(t, "MN", cl.lines(1)[0])
+	assert.Equal(t, "EN", cl.do("mg k\r\n"))
+}
+
+func TestMetaDelete(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "NF kk", cl.do("md k k\r\n"))
+	assert.Equal(t, "HD", cl.do("ms k 1\r\na\r\n"))
+	assert.Equal(t, "HD O1", cl.do("md k O1\r\n"))
+	assert.Equal(t, "EN", cl.do("mg k\r\n"))
+}
+
+func TestMetaArith(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "NF", cl.do("ma n\r\n"))
+	cl.send("ma n N0 J10 v\r\n")
+	assert.Equal(t, []string{"VA 2", "10"}, cl.lines(2))
+	assert.Equal(t, "HD", cl.do("ma n D5\r\n"))
+	cl.send("ma n MD D20 v\r\n")
+	assert.Equal(t, []string{"VA 1", "0"}, cl.lines(2))
+	assert.Equal(t, "CLIENT_ERROR bad token in command line format", cl.do("ma n Dx\r\n"))
+}
@@ -0,0 +1,212 @@
+// Package memcache serves a memory store connection over the memcached text and meta protocols
+// so services using memcached clients can share the cache
+//
+// values are stored as is, with their client flags kept alongside them, so keys are shared
+// with other clients of the connection; CAS tokens are the versions the cache gives every write
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+	"github.com/panoplymedia/local-cache-memorystore/server/internal"
+)
+
+// maxItemSize bounds the data a single storage command can make the server allocate
+const maxItemSize = 64 << 20
+
+// maxKeyLen is the longest key memcached accepts
+const maxKeyLen = 250
+
+var (
+	errTooLarge = errors.New("object too large for cache")
+	errBadChunk = errors.New("bad data chunk")
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+var ErrServerClosed = errors.New("memcache: Server closed")
+
+// Server serves a memory store connection over the memcached protocol
+type Server struct {
+	totalConnections uint64
+
+	conn      *memorystorecache.Conn
+	started   time.Time
+	listeners internal.Listeners
+}
+
+// NewServer creates a Server for conn
+func NewServer(conn *memorystorecache.Conn) *Server {
+	return &Server{
+		conn:    conn,
+		started: time.Now(),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts clients on ln until the listener fails or the server is closed
+func (s *Server) Serve(ln net.Listener) error {
+	return s.listeners.Serve(ln, ErrServerClosed, s.serveClient)
+}
+
+// Close stops the listeners and disconnects every client
+// the underlying memory store connection is left open
+func (s *Server) Close() error {
+	s.listeners.Close()
+	return nil
+}
+
+// client is the state of a single client connection
+type client struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (s *Server) serveClient(nc net.Conn) {
+	atomic.AddUint64(&s.totalConnections, 1)
+	c := &client{
+		r: bufio.NewReader(nc),
+		w: bufio.NewWriter(nc),
+	}
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		args := bytes.Fields(line)
+		if len(args) == 0 {
+			c.w.WriteString("ERROR\r\n")
+		} else if string(args[0]) == "quit" {
+			c.w.Flush()
+			return
+		} else if !s.dispatch(c, args) {
+			c.w.Flush()
+			return
+		}
+
+		if err := internal.FlushIdle(c.r, c.w); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch runs a command, returning false if the client should be disconnected
+// args alias the reader's buffer and are only valid until the next read
+func (s *Server) dispatch(c *client, args [][]byte) bool {
+	switch string(args[0]) {
+	case "get":
+		return s.cmdGet(c, args[1:], false)
+	case "gets":
+		return s.cmdGet(c, args[1:], true)
+	case "gat":
+		return s.cmdGat(c, args[1:], false)
+	case "gats":
+		return s.cmdGat(c, args[1:], true)
+	case "set":
+		return s.cmdStore(c, modeSet, args[1:])
+	case "add":
+		return s.cmdStore(c, modeAdd, args[1:])
+	case "replace":
+		return s.cmdStore(c, modeReplace, args[1:])
+	case "append":
+		return s.cmdStore(c, modeAppend, args[1:])
+	case "prepend":
+		return s.cmdStore(c, modePrepend, args[1:])
+	case "cas":
+		return s.cmdStore(c, modeCAS, args[1:])
+	case "delete":
+		return s.cmdDelete(c, args[1:])
+	case "incr":
+		return s.cmdArith(c, args[1:], true)
+	case "decr":
+		return s.cmdArith(c, args[1:], false)
+	case "touch":
+		return s.cmdTouch(c, args[1:])
+	case "stats":
+		return s.cmdStats(c, args[1:])
+	case "version":
+		c.w.WriteString("VERSION " + version + "\r\n")
+	case "verbosity":
+		c.w.WriteString("OK\r\n")
+	case "mg":
+		return s.metaGet(c, args[1:])
+	case "ms":
+		return s.metaSet(c, args[1:])
+	case "md":
+		return s.metaDelete(c, args[1:])
+	case "ma":
+		return s.metaArith(c, args[1:])
+	case "mn":
+		c.w.WriteString("MN\r\n")
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+	return true
+}
+
+// validKey reports whether a key is acceptable to memcached clients
+func validKey(key []byte) bool {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return false
+	}
+	for _, b := range key {
+		if b <= ' ' || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// readData reads a data block of n bytes followed by \r\n, answering the client if it is invalid
+// errTooLarge leaves the client in a state to read its next command, other errors do not
+func (c *client) readData(n int) ([]byte, error) {
+	if n > maxItemSize {
+		c.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		if _, err := c.r.Discard(n + 2); err != nil {
+			return nil, err
+		}
+		return nil, errTooLarge
+	}
+	// the block is allocated as it arrives rather than trusting the length the client sends
+	buf, err := internal.ReadBlock(c.r, n)
+	if err != nil {
+		return nil, err
+	}
+	var crlf [2]byte
+	if _, err := io.ReadFull(c.r, crlf[:]); err != nil {
+		return nil, err
+	}
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		c.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return nil, errBadChunk
+	}
+	return buf, nil
+}
+
+// writeError answers a failed write that is not one of the storage outcomes
+func (c *client) writeError(err error) {
+	if err == memorystorecache.ErrValueTooLarge {
+		c.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return
+	}
+	c.w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+}
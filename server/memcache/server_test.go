@@ -0,0 +1,278 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+	"github.com/panoplymedia/local-cache-memorystore/server/httpapi"
+	"github.com/panoplymedia/local-cache-memorystore/server/resp"
+)
+
+// testClient sends raw protocol lines and reads the replies
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func newTestServer(t *testing.T, opts ...memorystorecache.Option) (*memorystorecache.Conn, *testClient, func()) {
+	c, err := memorystorecache.NewCache(time.Minute, 0, opts...)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := NewServer(conn)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	cl := &testClient{t: t, nc: nc, r: bufio.NewReader(nc)}
+
+	return conn, cl, func() {
+		nc.Close()
+		srv.Close()
+		assert.Equal(t, ErrServerClosed, <-served)
+		conn.Close()
+	}
+}
+
+func (cl *testClient) send(s string) {
+	_, err := cl.nc.Write([]byte(s))
+	assert.Nil(cl.t, err)
+}
+
+// lines reads n reply lines, without their \r\n
+func (cl *testClient) lines(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		line, err := cl.r.ReadString('\n')
+		assert.Nil(cl.t, err)
+		out[i] = strings.TrimSuffix(line, "\r\n")
+	}
+	return out
+}
+
+func (cl *testClient) do(cmd string) string {
+	cl.send(cmd)
+	return cl.lines(1)[0]
+}
+
+func TestSetGet(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "STORED", cl.do("set k 42 0 5\r\nhello\r\n"))
+	cl.send("get k missing\r\n")
+	assert.Equal(t, []string{"VALUE k 42 5", "hello", "END"}, cl.lines(3))
+
+	assert.Equal(t, "ERROR", cl.do("bogus\r\n"))
+	assert.Equal(t, "CLIENT_ERROR bad data chunk", cl.do("set k 0 0 1\r\nab\r\n"))
+}
+
+func TestNoreply(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	cl.send("set k 0 0 1 noreply\r\na\r\nappend k 0 0 1 noreply\r\nb\r\nget k\r\n")
+	assert.Equal(t, []string{"VALUE k 0 2", "ab", "END"}, cl.lines(3))
+}
+
+func TestAddReplace(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "NOT_STORED", cl.do("replace k 0 0 1\r\na\r\n"))
+	assert.Equal(t, "STORED", cl.do("add k 0 0 1\r\na\r\n"))
+	assert.Equal(t, "NOT_STORED", cl.do("add k 0 0 1\r\nb\r\n"))
+	assert.Equal(t, "STORED", cl.do("replace k 0 0 1\r\nc\r\n"))
+	assert.Equal(t, "STORED", cl.do("prepend k 0 0 1\r\nb\r\n"))
+	cl.send("get k\r\n")
+	assert.Equal(t, []string{"VALUE k 0 2", "bc", "END"}, cl.lines(3))
+}
+
+func TestCAS(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "NOT_FOUND", cl.do("cas k 0 0 1 1\r\na\r\n"))
+	assert.Equal(t, "STORED", cl.do("set k 0 0 1\r\na\r\n"))
+	cl.send("gets k\r\n")
+	reply := cl.lines(3)
+	fields := strings.Fields(reply[0])
+	assert.Len(t, fields, 5)
+	token := fields[4]
+
+	assert.Equal(t, "STORED", cl.do("cas k 0 0 1 "+token+"\r\nb\r\n"))
+	assert.Equal(t, "EXISTS", cl.do("cas k 0 0 1 "+token+"\r\nc\r\n"))
+	cl.send("get k\r\n")
+	assert.Equal(t, []string{"VALUE k 0 1", "b", "END"}, cl.lines(3))
+}
+
+func TestDelete(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "NOT_FOUND", cl.do("delete k\r\n"))
+	assert.Equal(t, "STORED", cl.do("set k 0 0 1\r\na\r\n"))
+	assert.Equal(t, "DELETED", cl.do("delete k\r\n"))
+	assert.Equal(t, "END", cl.do("get k\r\n"))
+}
+
+func TestIncrDecr(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "NOT_FOUND", cl.do("incr n 1\r\n"))
+	assert.Equal(t, "STORED", cl.do("set n 7 0 2\r\n10\r\n"))
+	assert.Equal(t, "15", cl.do("incr n 5\r\n"))
+	assert.Equal(t, "0", cl.do("decr n 100\r\n"))
+	cl.send("get n\r\n")
+	assert.Equal(t, []string{"VALUE n 7 1", "0", "END"}, cl.lines(3))
+
+	assert.Equal(t, "STORED", cl.do("set s 0 0 1\r\nx\r\n"))
+	assert.Equal(t, "CLIENT_ERROR cannot increment or decrement non-numeric value", cl.do("incr s 1\r\n"))
+	assert.Equal(t, "CLIENT_ERROR invalid numeric delta argument", cl.do("incr n x\r\n"))
+}
+
+func TestTouch(t *testing.T) {
+	clk := memorystorecache.NewFakeClock(time.Now())
+	conn, cl, done := newTestServer(t, memorystorecache.WithClock(clk))
+	defer done()
+
+	assert.Equal(t, "NOT_FOUND", cl.do("touch k 10\r\n"))
+	assert.Equal(t, "STORED", cl.do("set k 0 0 1\r\na\r\n"))
+	ttl, err := conn.RemainingTTL([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	assert.Equal(t, "TOUCHED", cl.do("touch k 10\r\n"))
+	ttl, err = conn.RemainingTTL([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Second, ttl)
+
+	cl.send("gat 20 k\r\n")
+	assert.Equal(t, []string{"VALUE k 0 1", "a", "END"}, cl.lines(3))
+	ttl, err = conn.RemainingTTL([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, 20*time.Second, ttl)
+
+	clk.Advance(21 * time.Second)
+	assert.Equal(t, "END", cl.do("get k\r\n"))
+}
+
+func TestNegativeExptime(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "STORED", cl.do("set k 0 0 1\r\na\r\n"))
+	assert.Equal(t, "STORED", cl.do("set k 0 -1 1\r\nb\r\n"))
+	assert.Equal(t, "END", cl.do("get k\r\n"))
+}
+
+func TestAbsoluteExptime(t *testing.T) {
+	// absolute exptimes follow the connection's clock, not the system's
+	clk := memorystorecache.NewFakeClock(time.Unix(1500000000, 0))
+	conn, cl, done := newTestServer(t, memorystorecache.WithClock(clk))
+	defer done()
+
+	assert.Equal(t, "STORED", cl.do("set k 0 1500000100 1\r\na\r\n"))
+	ttl, err := conn.RemainingTTL([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, 100*time.Second, ttl)
+
+	clk.Advance(100 * time.Second)
+	assert.Equal(t, "END", cl.do("get k\r\n"))
+}
+
+func TestStats(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "STORED", cl.do("set k 0 0 1\r\na\r\n"))
+	cl.send("get k\r\n")
+	cl.lines(3)
+
+	cl.send("stats\r\n")
+	stats := map[string]string{}
+	for {
+		line := cl.lines(1)[0]
+		if line == "END" {
+			break
+		}
+		fields := strings.Fields(line)
+		assert.Equal(t, "STAT", fields[0])
+		stats[fields[1]] = fields[2]
+	}
+	assert.Equal(t, "1", stats["curr_items"])
+	assert.Equal(t, "1", stats["get_hits"])
+	assert.Equal(t, "1", stats["curr_connections"])
+	assert.Equal(t, "1", stats["memorystore_key_count"])
+	assert.Equal(t, version, stats["version"])
+}
+
+func TestQuit(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	cl.send("quit\r\n")
+	_, err := cl.r.ReadByte()
+	assert.NotNil(t, err)
+}
+
+func TestSharedWithOtherProtocols(t *testing.T) {
+	conn, cl, done := newTestServer(t)
+	defer done()
+
+	// a RESP client on the same connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	rs := resp.NewServer(conn)
+	go rs.Serve(ln)
+	defer rs.Close()
+	rc, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer rc.Close()
+	rr := bufio.NewReader(rc)
+	respDo := func(cmd string) string {
+		_, err := rc.Write([]byte(cmd))
+		assert.Nil(t, err)
+		var reply string
+		for {
+			line, err := rr.ReadString('\n')
+			assert.Nil(t, err)
+			reply += line
+			if err != nil || line[0] != '$' || line == "$-1\r\n" {
+				return reply
+			}
+		}
+	}
+
+	// values written over RESP are read with no flags
+	assert.Equal(t, "+OK\r\n", respDo("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$5\r\nhello\r\n"))
+	cl.send("get foo\r\n")
+	assert.Equal(t, []string{"VALUE foo 0 5", "hello", "END"}, cl.lines(3))
+
+	// memcache values keep their flags out of the data other clients see
+	assert.Equal(t, "STORED", cl.do("set bar 42 0 5\r\nworld\r\n"))
+	assert.Equal(t, "$5\r\nworld\r\n", respDo("*2\r\n$3\r\nGET\r\n$3\r\nbar\r\n"))
+	w := httptest.NewRecorder()
+	httpapi.NewHandler(conn).ServeHTTP(w, httptest.NewRequest("GET", "/keys/bar", nil))
+	assert.Equal(t, "world", w.Body.String())
+
+	// counters are shared both ways
+	assert.Equal(t, ":5\r\n", respDo("*3\r\n$6\r\nINCRBY\r\n$3\r\nctr\r\n$1\r\n5\r\n"))
+	assert.Equal(t, "6", cl.do("incr ctr 1\r\n"))
+	assert.Equal(t, ":7\r\n", respDo("*2\r\n$4\r\nINCR\r\n$3\r\nctr\r\n"))
+	cl.send("get ctr\r\n")
+	assert.Equal(t, []string{"VALUE ctr 0 1", "7", "END"}, cl.lines(3))
+}
@@ -0,0 +1,205 @@
+package memcache
+
+import (
+	"strconv"
+	"time"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+// storeMode is how a storage command treats an existing item
+type storeMode int
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeAppend
+	modePrepend
+	modeCAS
+)
+
+// get reads an item
+func (s *Server) get(key []byte) (item, bool) {
+	v, flags, version, err := s.conn.ReadFlags(key)
+	if err != nil {
+		return item{}, false
+	}
+	return item{flags: flags, cas: version, data: v}, true
+}
+
+// remainingTTL returns the TTL left on a key, 0 if it never expires
+func (s *Server) remainingTTL(key []byte) time.Duration {
+	ttl, err := s.conn.RemainingTTL(key)
+	if err != nil {
+		return 0
+	}
+	return ttl
+}
+
+// store writes an item according to mode, returning its new CAS token
+// cas is the token a modeCAS write expects the item to have
+// errNotStored, errNotFound and errExists report a write the mode did not allow
+func (s *Server) store(mode storeMode, key []byte, it item, ttl time.Duration, expired bool, cas uint64) (uint64, error) {
+	if expired {
+		// a write that expires immediately removes the key, if the mode allowed the write
+		return 0, s.expire(mode, key, cas)
+	}
+
+	var version uint64
+	var err error
+	switch mode {
+	case modeSet:
+		version, err = s.conn.WriteVersion(key, it.data, ttl, memorystorecache.WriteFlags(it.flags))
+	case modeAdd:
+		version, err = s.conn.WriteIfAbsent(key, it.data, ttl, memorystorecache.WriteFlags(it.flags))
+	case modeReplace:
+		version, err = s.conn.Replace(key, it.data, ttl, memorystorecache.WriteFlags(it.flags))
+	case modeCAS:
+		version, err = s.conn.CompareAndSwap(key, cas, it.data, ttl, memorystorecache.WriteFlags(it.flags))
+	case modeAppend, modePrepend:
+		return s.concat(mode, key, it.data)
+	}
+
+	switch err {
+	case memorystorecache.ErrKeyExists:
+		return 0, errNotStored
+	case memorystorecache.ErrKeyNotFound:
+		if mode == modeCAS {
+			return 0, errNotFound
+		}
+		return 0, errNotStored
+	case memorystorecache.ErrVersionMismatch:
+		return 0, errExists
+	}
+	return version, err
+}
+
+// expire removes a key for a write with an expiration time in the past
+func (s *Server) expire(mode storeMode, key []byte, cas uint64) error {
+	switch mode {
+	case modeAdd:
+		if s.conn.Exists(key) {
+			return errNotStored
+		}
+		return nil
+	case modeCAS:
+		switch s.conn.CompareAndDelete(key, cas) {
+		case memorystorecache.ErrKeyNotFound:
+			return errNotFound
+		case memorystorecache.ErrVersionMismatch:
+			return errExists
+		}
+		return nil
+	case modeSet:
+		s.conn.Delete(key)
+		return nil
+	}
+	if s.conn.Delete(key) != nil {
+		return errNotStored
+	}
+	return nil
+}
+
+// concat appends or prepends data to an item, keeping its flags and TTL
+// it retries when the item is written concurrently
+func (s *Server) concat(mode storeMode, key, data []byte) (uint64, error) {
+	for {
+		cur, found := s.get(key)
+		if !found {
+			return 0, errNotStored
+		}
+		next := item{flags: cur.flags, data: make([]byte, 0, len(cur.data)+len(data))}
+		if mode == modeAppend {
+			next.data = append(append(next.data, cur.data...), data...)
+		} else {
+			next.data = append(append(next.data, data...), cur.data...)
+		}
+
+		version, err := s.conn.CompareAndSwap(key, cur.cas, next.data, s.remainingTTL(key), memorystorecache.WriteFlags(next.flags))
+		switch err {
+		case memorystorecache.ErrVersionMismatch:
+			continue
+		case memorystorecache.ErrKeyNotFound:
+			return 0, errNotStored
+		}
+		return version, err
+	}
+}
+
+// vivify creates a missing counter in arith
+type vivify struct {
+	initial uint64
+	ttl     time.Duration
+}
+
+// arith increments or decrements a decimal counter, keeping its flags and TTL
+// decrements stop at 0 and increments wrap around, as in memcached
+// a missing key is created from viv if it is not nil
+func (s *Server) arith(key []byte, delta uint64, incr bool, viv *vivify) (uint64, uint64, error) {
+	for {
+		cur, found := s.get(key)
+		if !found {
+			if viv == nil {
+				return 0, 0, errNotFound
+			}
+			it := item{data: []byte(strconv.FormatUint(viv.initial, 10))}
+			version, err := s.conn.WriteIfAbsent(key, it.data, viv.ttl, memorystorecache.WriteFlags(it.flags))
+			if err == memorystorecache.ErrKeyExists {
+				continue
+			}
+			return viv.initial, version, err
+		}
+
+		n, err := strconv.ParseUint(string(cur.data), 10, 64)
+		if err != nil {
+			return 0, 0, errNonNumeric
+		}
+		switch {
+		case incr:
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+
+		it := item{flags: cur.flags, data: []byte(strconv.FormatUint(n, 10))}
+		version, err := s.conn.CompareAndSwap(key, cur.cas, it.data, s.remainingTTL(key), memorystorecache.WriteFlags(it.flags))
+		if err == memorystorecache.ErrVersionMismatch || err == memorystorecache.ErrKeyNotFound {
+			continue
+		}
+		return n, version, err
+	}
+}
+
+// touch sets the TTL of an existing key
+func (s *Server) touch(key []byte, ttl time.Duration, expired bool) error {
+	if expired {
+		if s.conn.Delete(key) != nil {
+			return errNotFound
+		}
+		return nil
+	}
+	if s.conn.TouchTTL(key, ttl) != nil {
+		return errNotFound
+	}
+	return nil
+}
+
+// del deletes a key, if cas is not 0 the key must have that CAS token
+func (s *Server) del(key []byte, cas uint64) error {
+	var err error
+	if cas != 0 {
+		err = s.conn.CompareAndDelete(key, cas)
+	} else {
+		err = s.conn.Delete(key)
+	}
+	switch err {
+	case nil:
+		return nil
+	case memorystorecache.ErrVersionMismatch:
+		return errExists
+	}
+	return errNotFound
+}
@@ -0,0 +1,241 @@
+package memcache
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/panoplymedia/local-cache-memorystore/server/internal"
+)
+
+// version is reported by the version and stats commands
+const version = "1.6.0-memorystore"
+
+// cmdGet implements get and gets <key>*
+func (s *Server) cmdGet(c *client, keys [][]byte, withCAS bool) bool {
+	if len(keys) == 0 {
+		c.w.WriteString("ERROR\r\n")
+		return true
+	}
+	for _, key := range keys {
+		if it, ok := s.get(key); ok {
+			writeValue(c, key, it, withCAS)
+		}
+	}
+	c.w.WriteString("END\r\n")
+	return true
+}
+
+// cmdGat implements gat and gats <exptime> <key>*, touching keys before reading them
+func (s *Server) cmdGat(c *client, args [][]byte, withCAS bool) bool {
+	if len(args) < 2 {
+		c.w.WriteString("ERROR\r\n")
+		return true
+	}
+	ttl, expired, err := parseExptime(args[0], s.conn.Now())
+	if err != nil {
+		c.w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return true
+	}
+	for _, key := range args[1:] {
+		if s.touch(key, ttl, expired) != nil {
+			continue
+		}
+		if it, ok := s.get(key); ok {
+			writeValue(c, key, it, withCAS)
+		}
+	}
+	c.w.WriteString("END\r\n")
+	return true
+}
+
+func writeValue(c *client, key []byte, it item, withCAS bool) {
+	c.w.WriteString("VALUE ")
+	c.w.Write(key)
+	c.w.WriteString(" " + strconv.FormatUint(uint64(it.flags), 10) + " " + strconv.Itoa(len(it.data)))
+	if withCAS {
+		c.w.WriteString(" " + strconv.FormatUint(it.cas, 10))
+	}
+	c.w.WriteString("\r\n")
+	c.w.Write(it.data)
+	c.w.WriteString("\r\n")
+}
+
+// noreply strips a trailing noreply argument
+func noreply(args [][]byte) ([][]byte, bool) {
+	if len(args) > 0 && string(args[len(args)-1]) == "noreply" {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
+
+// cmdStore implements <set|add|replace|append|prepend> <key> <flags> <exptime> <bytes> [noreply]
+// and cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (s *Server) cmdStore(c *client, mode storeMode, args [][]byte) bool {
+	args, quiet := noreply(args)
+	want := 4
+	if mode == modeCAS {
+		want = 5
+	}
+	if len(args) != want {
+		c.w.WriteString("ERROR\r\n")
+		return true
+	}
+
+	key := string(args[0])
+	flags, ferr := strconv.ParseUint(string(args[1]), 10, 32)
+	ttl, expired, eerr := parseExptime(args[2], s.conn.Now())
+	n, nerr := strconv.Atoi(string(args[3]))
+	var cas uint64
+	var cerr error
+	if mode == modeCAS {
+		cas, cerr = strconv.ParseUint(string(args[4]), 10, 64)
+	}
+	if !validKey(args[0]) || ferr != nil || eerr != nil || nerr != nil || n < 0 || cerr != nil {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+
+	data, err := c.readData(n)
+	if err == errTooLarge {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+
+	_, err = s.store(mode, []byte(key), item{flags: uint32(flags), data: data}, ttl, expired, cas)
+	if quiet {
+		return true
+	}
+	switch err {
+	case nil:
+		c.w.WriteString("STORED\r\n")
+	case errNotStored:
+		c.w.WriteString("NOT_STORED\r\n")
+	case errExists:
+		c.w.WriteString("EXISTS\r\n")
+	case errNotFound:
+		c.w.WriteString("NOT_FOUND\r\n")
+	default:
+		c.writeError(err)
+	}
+	return true
+}
+
+// cmdDelete implements delete <key> [noreply]
+func (s *Server) cmdDelete(c *client, args [][]byte) bool {
+	args, quiet := noreply(args)
+	if len(args) != 1 {
+		c.w.WriteString("ERROR\r\n")
+		return true
+	}
+	err := s.del(args[0], 0)
+	if quiet {
+		return true
+	}
+	if err != nil {
+		c.w.WriteString("NOT_FOUND\r\n")
+		return true
+	}
+	c.w.WriteString("DELETED\r\n")
+	return true
+}
+
+// cmdArith implements incr and decr <key> <value> [noreply]
+func (s *Server) cmdArith(c *client, args [][]byte, incr bool) bool {
+	args, quiet := noreply(args)
+	if len(args) != 2 {
+		c.w.WriteString("ERROR\r\n")
+		return true
+	}
+	delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return true
+	}
+
+	n, _, err := s.arith(args[0], delta, incr, nil)
+	if quiet {
+		return true
+	}
+	switch err {
+	case nil:
+		c.w.WriteString(strconv.FormatUint(n, 10) + "\r\n")
+	case errNotFound:
+		c.w.WriteString("NOT_FOUND\r\n")
+	case errNonNumeric:
+		c.w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+	default:
+		c.writeError(err)
+	}
+	return true
+}
+
+// cmdTouch implements touch <key> <exptime> [noreply]
+func (s *Server) cmdTouch(c *client, args [][]byte) bool {
+	args, quiet := noreply(args)
+	if len(args) != 2 {
+		c.w.WriteString("ERROR\r\n")
+		return true
+	}
+	ttl, expired, err := parseExptime(args[1], s.conn.Now())
+	if err != nil {
+		c.w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return true
+	}
+
+	err = s.touch(args[0], ttl, expired)
+	if quiet {
+		return true
+	}
+	if err != nil {
+		c.w.WriteString("NOT_FOUND\r\n")
+		return true
+	}
+	c.w.WriteString("TOUCHED\r\n")
+	return true
+}
+
+// cmdStats implements stats, reporting the server's stats followed by the connection's
+func (s *Server) cmdStats(c *client, args [][]byte) bool {
+	if len(args) > 0 {
+		// stats groups such as slabs and items have no equivalent
+		c.w.WriteString("END\r\n")
+		return true
+	}
+	st := s.conn.TypedStats()
+	now := time.Now()
+	stat := func(name string, v interface{}) {
+		fmt.Fprintf(c.w, "STAT %s %v\r\n", name, v)
+	}
+
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.started)/time.Second))
+	stat("time", now.Unix())
+	stat("version", version)
+	stat("curr_connections", s.listeners.Clients())
+	stat("total_connections", atomic.LoadUint64(&s.totalConnections))
+	stat("curr_items", st.KeyCount)
+	stat("bytes", st.Bytes)
+	stat("get_hits", st.Hits)
+	stat("get_misses", st.Misses)
+	stat("get_expired", st.ExpiredOnRead)
+	stat("cmd_get", st.Hits+st.Misses)
+	stat("cmd_set", st.Writes)
+	stat("delete_hits", st.Deletes)
+	stat("evictions", st.EvictionsMaxBytes+st.EvictionsMaxEntries)
+
+	stats, err := s.conn.Stats()
+	if err != nil {
+		c.w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+		return true
+	}
+	for _, st := range internal.Stats(stats) {
+		stat("memorystore_"+st.Name, st.Value)
+	}
+	c.w.WriteString("END\r\n")
+	return true
+}
@@ -0,0 +1,380 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+	"github.com/panoplymedia/local-cache-memorystore/server/internal"
+)
+
+// command handles a command, args exclude the command name
+type command struct {
+	// min and max bound the number of arguments, max is -1 when unbounded
+	min, max int
+	handler  func(s *Server, c *client, args [][]byte)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":        {0, 1, (*Server).ping},
+		"ECHO":        {1, 1, (*Server).echo},
+		"HELLO":       {0, -1, (*Server).hello},
+		"COMMAND":     {0, -1, (*Server).command},
+		"GET":         {1, 1, (*Server).get},
+		"SET":         {2, -1, (*Server).set},
+		"DEL":         {1, -1, (*Server).del},
+		"EXISTS":      {1, -1, (*Server).exists},
+		"EXPIRE":      {2, 2, (*Server).expire},
+		"TTL":         {1, 1, (*Server).ttl},
+		"PTTL":        {1, 1, (*Server).pttl},
+		"MGET":        {1, -1, (*Server).mget},
+		"MSET":        {2, -1, (*Server).mset},
+		"INCR":        {1, 1, (*Server).incr},
+		"DECR":        {1, 1, (*Server).decr},
+		"INCRBY":      {2, 2, (*Server).incrBy},
+		"DECRBY":      {2, 2, (*Server).decrBy},
+		"INCRBYFLOAT": {2, 2, (*Server).incrByFloat},
+		"SCAN":        {1, -1, (*Server).scan},
+		"INFO":        {0, -1, (*Server).info},
+	}
+}
+
+func (s *Server) dispatch(c *client, name string, args [][]byte) {
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.error(fmt.Sprintf("unknown command '%s'", name))
+		return
+	}
+	if len(args) < cmd.min || (cmd.max >= 0 && len(args) > cmd.max) {
+		c.w.error(fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+	cmd.handler(s, c, args)
+}
+
+func (s *Server) ping(c *client, args [][]byte) {
+	if len(args) == 0 {
+		c.w.simple("PONG")
+		return
+	}
+	c.w.bulk(args[0])
+}
+
+func (s *Server) echo(c *client, args [][]byte) {
+	c.w.bulk(args[0])
+}
+
+// hello negotiates the protocol version, ignoring AUTH and SETNAME
+func (s *Server) hello(c *client, args [][]byte) {
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil || (v != 2 && v != 3) {
+			c.w.WriteString("-NOPROTO unsupported protocol version\r\n")
+			return
+		}
+		c.w.proto = v
+	}
+
+	c.w.mapHeader(5)
+	c.w.bulkString("server")
+	c.w.bulkString("memorystore")
+	c.w.bulkString("proto")
+	c.w.integer(int64(c.w.proto))
+	c.w.bulkString("mode")
+	c.w.bulkString("standalone")
+	c.w.bulkString("role")
+	c.w.bulkString("master")
+	c.w.bulkString("modules")
+	c.w.array(0)
+}
+
+// command replies with an empty command table, enough for redis-cli to start
+func (s *Server) command(c *client, args [][]byte) {
+	c.w.array(0)
+}
+
+func (s *Server) get(c *client, args [][]byte) {
+	v, err := s.conn.Read(args[0])
+	if err != nil {
+		c.w.null()
+		return
+	}
+	c.w.bulk(v)
+}
+
+// set implements SET key value [EX seconds | PX milliseconds] [NX | XX]
+// keys without EX or PX use the cache's default TTL
+func (s *Server) set(c *client, args [][]byte) {
+	key, val := args[0], args[1]
+	ttl := s.conn.TTL
+	var nx, xx, expiry bool
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if expiry || i+1 == len(args) {
+				c.w.error("syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				c.w.error("value is not an integer or out of range")
+				return
+			}
+			unit := time.Second
+			if strings.EqualFold(string(args[i]), "PX") {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				c.w.error("invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+			expiry = true
+			i++
+		default:
+			c.w.error("syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		c.w.error("syntax error")
+		return
+	}
+
+	var err error
+	switch {
+	case nx:
+		_, err = s.conn.WriteIfAbsent(key, val, ttl)
+	case xx:
+		_, err = s.conn.Replace(key, val, ttl)
+	default:
+		err = s.conn.WriteTTL(key, val, ttl)
+	}
+	switch err {
+	case nil:
+		c.w.ok()
+	case memorystorecache.ErrKeyExists, memorystorecache.ErrKeyNotFound:
+		c.w.null()
+	default:
+		c.w.error(err.Error())
+	}
+}
+
+func (s *Server) del(c *client, args [][]byte) {
+	var n int64
+	for _, k := range args {
+		if s.conn.Delete(k) == nil {
+			n++
+		}
+	}
+	c.w.integer(n)
+}
+
+func (s *Server) exists(c *client, args [][]byte) {
+	var n int64
+	for _, k := range args {
+		if s.conn.Exists(k) {
+			n++
+		}
+	}
+	c.w.integer(n)
+}
+
+// expire implements EXPIRE key seconds, a non positive TTL deletes the key
+func (s *Server) expire(c *client, args [][]byte) {
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || n > math.MaxInt64/int64(time.Second) {
+		c.w.error("value is not an integer or out of range")
+		return
+	}
+	if n <= 0 {
+		if s.conn.Delete(args[0]) == nil {
+			c.w.integer(1)
+			return
+		}
+		c.w.integer(0)
+		return
+	}
+	if s.conn.TouchTTL(args[0], time.Duration(n)*time.Second) != nil {
+		c.w.integer(0)
+		return
+	}
+	c.w.integer(1)
+}
+
+// remainingTTL returns the TTL of a key in unit, -2 for a missing key and -1 for a key that never expires
+func (s *Server) remainingTTL(key []byte, unit time.Duration) int64 {
+	ttl, err := s.conn.RemainingTTL(key)
+	if err != nil {
+		return -2
+	}
+	if ttl == 0 {
+		return -1
+	}
+	return int64((ttl + unit/2) / unit)
+}
+
+func (s *Server) ttl(c *client, args [][]byte) {
+	c.w.integer(s.remainingTTL(args[0], time.Second))
+}
+
+func (s *Server) pttl(c *client, args [][]byte) {
+	c.w.integer(s.remainingTTL(args[0], time.Millisecond))
+}
+
+func (s *Server) mget(c *client, args [][]byte) {
+	found, _ := s.conn.MultiRead(args)
+	c.w.array(len(args))
+	for _, k := range args {
+		v, ok := found[string(k)]
+		if !ok {
+			c.w.null()
+			continue
+		}
+		c.w.bulk(v)
+	}
+}
+
+func (s *Server) mset(c *client, args [][]byte) {
+	if len(args)%2 != 0 {
+		c.w.error("wrong number of arguments for 'mset' command")
+		return
+	}
+	entries := make([]memorystorecache.Entry, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		entries = append(entries, memorystorecache.Entry{Key: args[i], Value: args[i+1]})
+	}
+	if err := s.conn.MultiWrite(entries, s.conn.TTL); err != nil {
+		c.w.error(err.Error())
+		return
+	}
+	c.w.ok()
+}
+
+func (s *Server) incr(c *client, args [][]byte) {
+	s.incrKey(c, args[0], 1)
+}
+
+func (s *Server) decr(c *client, args [][]byte) {
+	s.incrKey(c, args[0], -1)
+}
+
+func (s *Server) incrBy(c *client, args [][]byte) {
+	s.incrByDelta(c, args, 1)
+}
+
+func (s *Server) decrBy(c *client, args [][]byte) {
+	s.incrByDelta(c, args, -1)
+}
+
+// incrKey adds delta to a key for INCR, DECR, INCRBY and DECRBY, keeping the key's TTL
+// a missing key starts at 0 with the cache's default TTL
+func (s *Server) incrKey(c *client, key []byte, delta int64) {
+	n, err := s.conn.Incr(key, delta, memorystorecache.KeepTTL)
+	switch err {
+	case nil:
+		c.w.integer(n)
+	case memorystorecache.ErrNotNumber:
+		c.w.error("value is not an integer or out of range")
+	case memorystorecache.ErrOverflow:
+		c.w.error("increment or decrement would overflow")
+	default:
+		c.w.error(err.Error())
+	}
+}
+
+// incrByDelta parses the delta of INCRBY and DECRBY
+func (s *Server) incrByDelta(c *client, args [][]byte, sign int64) {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || (sign < 0 && delta == math.MinInt64) {
+		c.w.error("value is not an integer or out of range")
+		return
+	}
+	s.incrKey(c, args[0], sign*delta)
+}
+
+// incrByFloat implements INCRBYFLOAT key increment
+func (s *Server) incrByFloat(c *client, args [][]byte) {
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		c.w.error("value is not a valid float")
+		return
+	}
+	f, err := s.conn.IncrFloat(args[0], delta, memorystorecache.KeepTTL)
+	switch err {
+	case nil:
+		c.w.bulkString(strconv.FormatFloat(f, 'f', -1, 64))
+	case memorystorecache.ErrNotNumber:
+		c.w.error("value is not a valid float")
+	case memorystorecache.ErrOverflow:
+		c.w.error("increment would produce NaN or Infinity")
+	default:
+		c.w.error(err.Error())
+	}
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]
+func (s *Server) scan(c *client, args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		c.w.error("invalid cursor")
+		return
+	}
+
+	var pattern []byte
+	count := 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.w.error("syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count < 1 {
+				c.w.error("value is not an integer or out of range")
+				return
+			}
+		default:
+			c.w.error("syntax error")
+			return
+		}
+	}
+
+	keys, next := s.conn.Scan(cursor, pattern, count)
+	c.w.array(2)
+	c.w.bulkString(strconv.FormatUint(next, 10))
+	c.w.array(len(keys))
+	for _, k := range keys {
+		c.w.bulk(k)
+	}
+}
+
+// info replies with the connection's stats
+func (s *Server) info(c *client, args [][]byte) {
+	stats, err := s.conn.Stats()
+	if err != nil {
+		c.w.error(err.Error())
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# Stats\r\n")
+	for _, st := range internal.Stats(stats) {
+		fmt.Fprintf(&b, "%s:%v\r\n", st.Name, st.Value)
+	}
+	c.w.bulkString(b.String())
+}
@@ -0,0 +1,180 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/panoplymedia/local-cache-memorystore/server/internal"
+)
+
+const (
+	// maxArgs and maxBulkLen bound the memory a single command can make the server allocate
+	maxArgs    = 1 << 16
+	maxBulkLen = 64 << 20
+
+	// argsChunk caps the arguments allocated up front for a command,
+	// the rest are allocated as they arrive rather than trusting the count the client sends
+	argsChunk = 64
+)
+
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads a command sent as an array of bulk strings, or as an inline command
+// it returns no arguments for an empty line
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	// inline commands, as typed into telnet
+	// the fields are copied out of the reader's buffer, which the next read reuses
+	if line[0] != '*' {
+		args := bytes.Fields(line)
+		for i, f := range args {
+			args[i] = append([]byte(nil), f...)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, errProtocol
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	args := make([][]byte, 0, minInt(n, argsChunk))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		l, err := strconv.Atoi(string(line[1:]))
+		if err != nil || l < 0 || l > maxBulkLen {
+			return nil, errProtocol
+		}
+
+		arg, err := readBulk(r, l)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string of l bytes followed by \r\n
+func readBulk(r *bufio.Reader, l int) ([]byte, error) {
+	buf, err := internal.ReadBlock(r, l)
+	if err != nil {
+		return nil, err
+	}
+
+	var crlf [2]byte
+	if _, err := io.ReadFull(r, crlf[:]); err != nil {
+		return nil, err
+	}
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return nil, errProtocol
+	}
+	return buf, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// readLine reads a line terminated by \r\n or \n, without the terminator
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// writer encodes replies in the protocol version negotiated with HELLO
+type writer struct {
+	*bufio.Writer
+	proto int
+}
+
+func (w *writer) simple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) ok() {
+	w.simple("OK")
+}
+
+func (w *writer) error(msg string) {
+	w.WriteString("-ERR ")
+	w.WriteString(msg)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+func (w *writer) bulk(b []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) bulkString(s string) {
+	w.bulk([]byte(s))
+}
+
+func (w *writer) null() {
+	if w.proto >= 3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+func (w *writer) array(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
+
+// mapHeader starts a map of n pairs, sent as a flat array under RESP2
+func (w *writer) mapHeader(n int) {
+	if w.proto >= 3 {
+		w.WriteByte('%')
+		w.WriteString(strconv.Itoa(n))
+		w.WriteString("\r\n")
+		return
+	}
+	w.array(2 * n)
+}
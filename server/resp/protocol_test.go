@@ -0,0 +1,81 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/panoplymedia/local-cache-memorystore/server/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n"))
+	args, err := readCommand(r)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("SET"), []byte("k"), {}}, args)
+}
+
+func TestReadCommandInline(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("GET  key\r\n\r\n"))
+	args, err := readCommand(r)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("GET"), []byte("key")}, args)
+
+	args, err = readCommand(r)
+	assert.Nil(t, err)
+	assert.Len(t, args, 0)
+}
+
+func TestReadCommandInvalid(t *testing.T) {
+	for _, in := range []string{
+		"*x\r\n",
+		"*1\r\n:1\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n$3\r\nabcd\r\n",
+		"*65537\r\n",
+		"*1\r\n$67108865\r\n",
+	} {
+		_, err := readCommand(bufio.NewReader(strings.NewReader(in)))
+		assert.Equal(t, errProtocol, err, in)
+	}
+}
+
+func TestReadCommandLarge(t *testing.T) {
+	// a value spanning several chunks is read whole, into a buffer of exactly its size
+	v := bytes.Repeat([]byte("v"), 3*internal.BlockChunk+1)
+	in := "*2\r\n$3\r\nSET\r\n$" + strconv.Itoa(len(v)) + "\r\n" + string(v) + "\r\n"
+	args, err := readCommand(bufio.NewReader(strings.NewReader(in)))
+	assert.Nil(t, err)
+	assert.Len(t, args, 2)
+	assert.Equal(t, v, args[1])
+	assert.Equal(t, len(v), cap(args[1]))
+}
+
+func TestReadCommandTruncated(t *testing.T) {
+	// lengths the client does not follow with data are not allocated up front
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for _, in := range []string{
+		"*65536\r\n$3\r\nabc\r\n",
+		"*1\r\n$67108864\r\nabc",
+	} {
+		_, err := readCommand(bufio.NewReader(strings.NewReader(in)))
+		assert.NotNil(t, err, in)
+	}
+	runtime.ReadMemStats(&after)
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 1<<20, "allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+}
+
+func TestWriterNull(t *testing.T) {
+	var buf bytes.Buffer
+	w := &writer{Writer: bufio.NewWriter(&buf), proto: 2}
+	w.null()
+	w.proto = 3
+	w.null()
+	w.Flush()
+	assert.Equal(t, "$-1\r\n_\r\n", buf.String())
+}
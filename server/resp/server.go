@@ -0,0 +1,86 @@
+// Package resp serves a memory store connection over the Redis protocol (RESP2 and RESP3)
+// so redis-cli and non Go clients can share the cache
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+	"github.com/panoplymedia/local-cache-memorystore/server/internal"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+var ErrServerClosed = errors.New("resp: Server closed")
+
+// Server serves a memory store connection over the Redis protocol
+type Server struct {
+	conn      *memorystorecache.Conn
+	listeners internal.Listeners
+}
+
+// NewServer creates a Server for conn
+func NewServer(conn *memorystorecache.Conn) *Server {
+	return &Server{conn: conn}
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts clients on ln until the listener fails or the server is closed
+func (s *Server) Serve(ln net.Listener) error {
+	return s.listeners.Serve(ln, ErrServerClosed, s.serveClient)
+}
+
+// Close stops the listeners and disconnects every client
+// the underlying memory store connection is left open
+func (s *Server) Close() error {
+	s.listeners.Close()
+	return nil
+}
+
+// client is the state of a single client connection
+type client struct {
+	r *bufio.Reader
+	w *writer
+}
+
+func (s *Server) serveClient(nc net.Conn) {
+	c := &client{
+		r: bufio.NewReader(nc),
+		w: &writer{Writer: bufio.NewWriter(nc), proto: 2},
+	}
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			if err == errProtocol {
+				c.w.error("Protocol error")
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(string(args[0]))
+		if name == "QUIT" {
+			c.w.ok()
+			c.w.Flush()
+			return
+		}
+		s.dispatch(c, name, args[1:])
+
+		if err := internal.FlushIdle(c.r, c.w.Writer); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	memorystorecache "github.com/panoplymedia/local-cache-memorystore"
+)
+
+// testClient is a minimal RESP client
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// respError is an error reply
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+func newTestServer(t *testing.T, opts ...memorystorecache.Option) (*memorystorecache.Conn, *testClient, func()) {
+	c, err := memorystorecache.NewCache(time.Minute, 0, opts...)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := NewServer(conn)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	cl := &testClient{t: t, nc: nc, r: bufio.NewReader(nc)}
+
+	return conn, cl, func() {
+		nc.Close()
+		srv.Close()
+		assert.Equal(t, ErrServerClosed, <-served)
+		conn.Close()
+	}
+}
+
+func (cl *testClient) send(args ...string) {
+	w := bufio.NewWriter(cl.nc)
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		w.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	assert.Nil(cl.t, w.Flush())
+}
+
+func (cl *testClient) do(args ...string) interface{} {
+	cl.send(args...)
+	return cl.reply()
+}
+
+// reply reads a reply, bulk strings are returned as strings and errors as respError
+func (cl *testClient) reply() interface{} {
+	line, err := cl.r.ReadString('\n')
+	assert.Nil(cl.t, err)
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return respError(line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		_, err := io.ReadFull(cl.r, buf)
+		assert.Nil(cl.t, err)
+		return string(buf[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			arr[i] = cl.reply()
+		}
+		return arr
+	}
+	cl.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func TestPing(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "PONG", cl.do("PING"))
+	assert.Equal(t, "hi", cl.do("ping", "hi"))
+	assert.Equal(t, "hi", cl.do("ECHO", "hi"))
+	assert.Equal(t, respError("ERR unknown command 'NOPE'"), cl.do("NOPE"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'get' command"), cl.do("GET"))
+}
+
+func TestInline(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	_, err := cl.nc.Write([]byte("SET k v\r\nGET k\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", cl.reply())
+	assert.Equal(t, "v", cl.reply())
+}
+
+func TestInlineKeepsValues(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	_, err := cl.nc.Write([]byte("SET foo barbaz\r\nSET qqq zzzzzz\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", cl.reply())
+	assert.Equal(t, "OK", cl.reply())
+	assert.Equal(t, "barbaz", cl.do("GET", "foo"))
+}
+
+func TestPipeline(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	for i := 0; i < 100; i++ {
+		cl.send("SET", strconv.Itoa(i), strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "OK", cl.reply())
+	}
+	assert.Equal(t, "42", cl.do("GET", "42"))
+}
+
+func TestHello(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Nil(t, cl.do("GET", "missing"))
+	reply := cl.do("HELLO", "3").([]interface{})
+	assert.Equal(t, []interface{}{"server", "memorystore", "proto", int64(3)}, reply[:4])
+	assert.Nil(t, cl.do("GET", "missing"))
+	assert.Equal(t, respError("NOPROTO unsupported protocol version"), cl.do("HELLO", "4"))
+}
+
+func TestSet(t *testing.T) {
+	clk := memorystorecache.NewFakeClock(time.Now())
+	conn, cl, done := newTestServer(t, memorystorecache.WithClock(clk))
+	defer done()
+
+	assert.Equal(t, "OK", cl.do("SET", "k", "v"))
+	assert.Equal(t, "v", cl.do("GET", "k"))
+	ttl, err := conn.RemainingTTL([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	assert.Equal(t, "OK", cl.do("SET", "k", "v", "EX", "10"))
+	assert.Equal(t, int64(10), cl.do("TTL", "k"))
+	assert.Equal(t, "OK", cl.do("SET", "k", "v", "px", "1500"))
+	assert.Equal(t, int64(2), cl.do("TTL", "k"))
+	assert.Equal(t, int64(1500), cl.do("PTTL", "k"))
+
+	assert.Nil(t, cl.do("SET", "k", "other", "NX"))
+	assert.Equal(t, "v", cl.do("GET", "k"))
+	assert.Equal(t, "OK", cl.do("SET", "k", "other", "XX"))
+	assert.Equal(t, "other", cl.do("GET", "k"))
+	assert.Nil(t, cl.do("SET", "new", "v", "XX"))
+	assert.Equal(t, "OK", cl.do("SET", "new", "v", "NX"))
+
+	assert.Equal(t, respError("ERR syntax error"), cl.do("SET", "k", "v", "NX", "XX"))
+	assert.Equal(t, respError("ERR syntax error"), cl.do("SET", "k", "v", "EX"))
+	assert.Equal(t, respError("ERR value is not an integer or out of range"), cl.do("SET", "k", "v", "EX", "x"))
+	assert.Equal(t, respError("ERR invalid expire time in 'set' command"), cl.do("SET", "k", "v", "EX", "0"))
+}
+
+func TestDelExists(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "OK", cl.do("MSET", "a", "1", "b", "2"))
+	assert.Equal(t, int64(2), cl.do("EXISTS", "a", "b", "c"))
+	assert.Equal(t, int64(1), cl.do("DEL", "a", "c"))
+	assert.Equal(t, int64(1), cl.do("EXISTS", "a", "b"))
+	assert.Equal(t, []interface{}{nil, "2"}, cl.do("MGET", "a", "b"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'mset' command"), cl.do("MSET", "a", "1", "b"))
+}
+
+func TestExpire(t *testing.T) {
+	clk := memorystorecache.NewFakeClock(time.Now())
+	_, cl, done := newTestServer(t, memorystorecache.WithClock(clk))
+	defer done()
+
+	assert.Equal(t, int64(-2), cl.do("TTL", "k"))
+	assert.Equal(t, int64(0), cl.do("EXPIRE", "k", "10"))
+
+	assert.Equal(t, "OK", cl.do("SET", "k", "v"))
+	assert.Equal(t, int64(1), cl.do("EXPIRE", "k", "10"))
+	assert.Equal(t, int64(10), cl.do("TTL", "k"))
+	clk.Advance(11 * time.Second)
+	assert.Nil(t, cl.do("GET", "k"))
+
+	assert.Equal(t, "OK", cl.do("SET", "k", "v"))
+	assert.Equal(t, int64(1), cl.do("EXPIRE", "k", "0"))
+	assert.Equal(t, int64(0), cl.do("EXISTS", "k"))
+}
+
+func TestTTLNeverExpires(t *testing.T) {
+	conn, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Nil(t, conn.WriteTTL([]byte("k"), []byte("v"), 0))
+	assert.Equal(t, int64(-1), cl.do("TTL", "k"))
+}
+
+func TestIncr(t *testing.T) {
+	clk := memorystorecache.NewFakeClock(time.Now())
+	_, cl, done := newTestServer(t, memorystorecache.WithClock(clk))
+	defer done()
+
+	assert.Equal(t, int64(1), cl.do("INCR", "n"))
+	assert.Equal(t, int64(2), cl.do("INCR", "n"))
+
+	assert.Equal(t, "OK", cl.do("SET", "n", "41", "EX", "100"))
+	assert.Equal(t, int64(42), cl.do("INCR", "n"))
+	assert.Equal(t, int64(100), cl.do("TTL", "n"))
+
+	assert.Equal(t, int64(40), cl.do("DECRBY", "n", "2"))
+	assert.Equal(t, int64(39), cl.do("DECR", "n"))
+	assert.Equal(t, int64(49), cl.do("INCRBY", "n", "10"))
+	assert.Equal(t, "49.5", cl.do("INCRBYFLOAT", "n", "0.5"))
+
+	assert.Equal(t, "OK", cl.do("SET", "s", "x"))
+	assert.Equal(t, respError("ERR value is not an integer or out of range"), cl.do("INCR", "s"))
+	assert.Equal(t, respError("ERR value is not an integer or out of range"), cl.do("INCRBY", "n", "x"))
+	assert.Equal(t, "OK", cl.do("SET", "max", "9223372036854775807"))
+	assert.Equal(t, respError("ERR increment or decrement would overflow"), cl.do("INCR", "max"))
+}
+
+func TestScan(t *testing.T) {
+	_, cl, done := newTestServer(t, memorystorecache.WithShards(4))
+	defer done()
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		k := "user:" + strconv.Itoa(i)
+		want = append(want, k)
+		assert.Equal(t, "OK", cl.do("SET", k, "v"))
+	}
+	assert.Equal(t, "OK", cl.do("SET", "episode:1", "v"))
+
+	var keys []string
+	cursor := "0"
+	for {
+		reply := cl.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "5").([]interface{})
+		for _, k := range reply[1].([]interface{}) {
+			keys = append(keys, k.(string))
+		}
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(keys)
+	sort.Strings(want)
+	assert.Equal(t, want, keys)
+
+	reply := cl.do("SCAN", "0", "COUNT", "1000").([]interface{})
+	assert.Equal(t, "0", reply[0])
+	assert.Len(t, reply[1], 51)
+	assert.Equal(t, respError("ERR syntax error"), cl.do("SCAN", "0", "MATCH"))
+}
+
+func TestInfo(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "OK", cl.do("SET", "k", "v"))
+	cl.do("GET", "k")
+	info := cl.do("INFO").(string)
+	assert.Contains(t, info, "# Stats\r\n")
+	assert.Contains(t, info, "key_count:1\r\n")
+	assert.Contains(t, info, "hits:1\r\n")
+}
+
+func TestQuit(t *testing.T) {
+	_, cl, done := newTestServer(t)
+	defer done()
+
+	assert.Equal(t, "OK", cl.do("QUIT"))
+	_, err := cl.r.ReadByte()
+	assert.NotNil(t, err)
+}
+
+func TestServeAfterClose(t *testing.T) {
+	c, err := memorystorecache.NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	srv := NewServer(conn)
+	assert.Nil(t, srv.Close())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrServerClosed, srv.Serve(ln))
+}
@@ -0,0 +1,340 @@
+package memorystorecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// snapshot layout, all integers are varints unless noted
+//
+//	header:  "MSSN" version(1 byte)
+//	entry:   1(byte) element
+//	trailer: 0(byte) entryCount crc32(4 bytes, big endian, of everything before it)
+//
+// an element is encoded as
+//
+//	keyLen key valueLen value expiresAt staleAt ttl softTTL delta tagCount (tagLen tag)* flags
+//
+// times are unix nanoseconds, with 0 for the zero time
+// version 1 elements end after delta, without tags, and version 2 elements end after the tags, without flags
+const (
+	snapshotMagic   = "MSSN"
+	snapshotVersion = 3
+
+	recordEnd   = 0
+	recordEntry = 1
+)
+
+var (
+	// ErrInvalidSnapshot is returned when a snapshot is truncated or malformed
+	ErrInvalidSnapshot = errors.New("Invalid snapshot")
+	// ErrSnapshotVersion is returned when a snapshot was written by an unsupported version
+	ErrSnapshotVersion = errors.New("Unsupported snapshot version")
+	// ErrSnapshotChecksum is returned when a snapshot fails its checksum
+	ErrSnapshotChecksum = errors.New("Snapshot checksum mismatch")
+)
+
+// encodeWriter is implemented by bufio.Writer and bytes.Buffer
+type encodeWriter interface {
+	io.Writer
+	io.ByteWriter
+	WriteString(s string) (int, error)
+}
+
+// decodeReader is implemented by crcReader and bytes.Reader
+type decodeReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// snapshotEntry is a key and element copied out of a shard
+type snapshotEntry struct {
+	key string
+	el  cacheElement
+}
+
+// Snapshot writes every unexpired key to w
+// shards are copied one at a time, so writes to other shards are not blocked,
+// but the snapshot is not a consistent point in time across shards
+func (c *Conn) Snapshot(w io.Writer) error {
+	h := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, h))
+
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+
+	var count uint64
+	var entries []snapshotEntry
+	for i := range c.shards {
+		entries = c.copyShard(i, entries[:0])
+		for _, e := range entries {
+			bw.WriteByte(recordEntry)
+			encodeElement(bw, e.key, e.el)
+			count++
+		}
+	}
+
+	bw.WriteByte(recordEnd)
+	writeUvarint(bw, count)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], h.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// copyShard appends the unexpired entries of a shard to entries
+func (c *Conn) copyShard(idx int, entries []snapshotEntry) []snapshotEntry {
+	return c.copyShardPrefix(idx, "", entries)
+}
+
+// OpenFromSnapshot opens a new connection to the memory store, loaded from a snapshot
+// keys that expired since the snapshot was taken are skipped
+func (c Cache) OpenFromSnapshot(r io.Reader) (*Conn, error) {
+	conn, err := c.Open("")
+	if err != nil {
+		return nil, err
+	}
+	// keys over the memory budget are dropped like any other eviction
+	err = conn.loadSnapshot(r, func(key string, el cacheElement) {
+		el.fill = true
+		conn.writeElement(key, el)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Restore writes the keys of a snapshot into an open connection, on top of the keys it already holds
+// the whole snapshot is read and verified before any key is written
+func (c *Conn) Restore(r io.Reader) error {
+	var entries []snapshotEntry
+	err := c.loadSnapshot(r, func(key string, el cacheElement) {
+		el.fill = true
+		entries = append(entries, snapshotEntry{key: key, el: el})
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := c.writeElement(e.key, e.el); err != nil && err != ErrValueTooLarge {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSnapshot reads a snapshot, passing every unexpired entry to apply
+func (c *Conn) loadSnapshot(r io.Reader, apply func(key string, el cacheElement)) error {
+	cr := &crcReader{r: bufio.NewReader(r), h: crc32.NewIEEE()}
+
+	var header [len(snapshotMagic) + 1]byte
+	if _, err := io.ReadFull(cr, header[:]); err != nil {
+		return ErrInvalidSnapshot
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrInvalidSnapshot
+	}
+	version := header[len(snapshotMagic)]
+	if version < 1 || version > snapshotVersion {
+		return ErrSnapshotVersion
+	}
+
+	t := c.now()
+	var count uint64
+	for {
+		kind, err := cr.ReadByte()
+		if err != nil {
+			return ErrInvalidSnapshot
+		}
+		if kind == recordEnd {
+			break
+		}
+		if kind != recordEntry {
+			return ErrInvalidSnapshot
+		}
+
+		key, el, err := decodeElement(cr, version)
+		if err != nil {
+			return ErrInvalidSnapshot
+		}
+		count++
+		if el.expired(t) {
+			continue
+		}
+		apply(key, el)
+	}
+
+	n, err := binary.ReadUvarint(cr)
+	if err != nil || n != count {
+		return ErrInvalidSnapshot
+	}
+	expected := cr.h.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(cr.r, sum[:]); err != nil {
+		return ErrInvalidSnapshot
+	}
+	if binary.BigEndian.Uint32(sum[:]) != expected {
+		return ErrSnapshotChecksum
+	}
+	return nil
+}
+
+// crcReader hashes everything read through it
+type crcReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.h.Write(p[:n])
+	return n, err
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+// encodeElement encodes a key and element
+func encodeElement(w encodeWriter, key string, el cacheElement) {
+	writeUvarint(w, uint64(len(key)))
+	w.WriteString(key)
+	writeUvarint(w, uint64(len(el.dat)))
+	w.Write(el.dat)
+	writeVarint(w, unixNano(el.expiresAt))
+	writeVarint(w, unixNano(el.staleAt))
+	writeVarint(w, int64(el.ttl))
+	writeVarint(w, int64(el.softTTL))
+	writeVarint(w, int64(el.delta))
+	writeUvarint(w, uint64(len(el.tags)))
+	for _, tag := range el.tags {
+		writeUvarint(w, uint64(len(tag)))
+		w.WriteString(tag)
+	}
+	writeUvarint(w, uint64(el.flags))
+}
+
+// decodeElement decodes a key and element written by encodeElement for a format version
+func decodeElement(r decodeReader, version byte) (string, cacheElement, error) {
+	var el cacheElement
+	key, err := readBytes(r)
+	if err != nil {
+		return "", el, err
+	}
+	if el.dat, err = readBytes(r); err != nil {
+		return "", el, err
+	}
+
+	var ints [5]int64
+	for i := range ints {
+		if ints[i], err = binary.ReadVarint(r); err != nil {
+			return "", el, err
+		}
+	}
+	el.expiresAt = fromUnixNano(ints[0])
+	el.staleAt = fromUnixNano(ints[1])
+	el.ttl = time.Duration(ints[2])
+	el.softTTL = time.Duration(ints[3])
+	el.delta = time.Duration(ints[4])
+	if version < 2 {
+		return string(key), el, nil
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", el, err
+	}
+	// a corrupt count fails on the first missing tag rather than allocating up front
+	for i := uint64(0); i < n; i++ {
+		tag, err := readBytes(r)
+		if err != nil {
+			return "", el, err
+		}
+		el.tags = append(el.tags, string(tag))
+	}
+	if version < 3 {
+		return string(key), el, nil
+	}
+
+	flags, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", el, err
+	}
+	if flags > math.MaxUint32 {
+		return "", el, errOutOfRange
+	}
+	el.flags = uint32(flags)
+	return string(key), el, nil
+}
+
+func readBytes(r decodeReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	return readBytesN(r, n)
+}
+
+// maxFieldLen bounds the length of a single key, value or record read back from disk
+const maxFieldLen = math.MaxInt32
+
+// errOutOfRange is returned for a length or number too large for its field, which can only come from corrupt input
+var errOutOfRange = errors.New("encoded value out of range")
+
+// readBytesN reads n bytes in bounded chunks, so a corrupt length fails
+// once the input runs out instead of allocating its full size up front
+func readBytesN(r io.Reader, n uint64) ([]byte, error) {
+	if n > maxFieldLen {
+		return nil, errOutOfRange
+	}
+	b := make([]byte, 0, minInt(int(n), 64<<10))
+	for uint64(len(b)) < n {
+		chunk := minInt(int(n-uint64(len(b))), 64<<10)
+		start := len(b)
+		b = append(b, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r, b[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func writeUvarint(w io.Writer, x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
+
+func writeVarint(w io.Writer, x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutVarint(buf[:], x)])
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,189 @@
+package memorystorecache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 100; i++ {
+		err = conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte{byte(i)})
+		assert.Nil(t, err)
+	}
+	err = conn.WriteTTL([]byte("forever"), []byte("data"), 0)
+	assert.Nil(t, err)
+	err = conn.WriteSoftTTL([]byte("soft"), []byte("data"), time.Minute, time.Hour)
+	assert.Nil(t, err)
+	err = conn.WriteTTL([]byte("expiring"), []byte("data"), 100*time.Millisecond)
+	assert.Nil(t, err)
+
+	buf := bytes.Buffer{}
+	assert.Nil(t, conn.Snapshot(&buf))
+
+	// expired keys are skipped on load
+	clk.Advance(100 * time.Millisecond)
+	restored, err := c.OpenFromSnapshot(&buf)
+	assert.Nil(t, err)
+	defer restored.Close()
+
+	assert.Equal(t, uint64(102), restored.keyCount())
+	for i := 0; i < 100; i++ {
+		b, err := restored.Read([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(i)}, b)
+	}
+	assert.False(t, restored.Exists([]byte("expiring")))
+
+	// expiry times survive the round trip
+	ttl, err := restored.RemainingTTL([]byte("forever"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+	orig, err := conn.RemainingTTL([]byte("key-0"))
+	assert.Nil(t, err)
+	ttl, err = restored.RemainingTTL([]byte("key-0"))
+	assert.Nil(t, err)
+	assert.InDelta(t, float64(orig), float64(ttl), float64(time.Second))
+
+	s := restored.shardFor("soft")
+	el := s.dat["soft"]
+	assert.Equal(t, time.Minute, el.softTTL)
+	assert.Equal(t, time.Hour, el.ttl)
+	assert.False(t, el.staleAt.IsZero())
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	err = conn.Write([]byte("key"), []byte("data"))
+	assert.Nil(t, err)
+	buf := bytes.Buffer{}
+	assert.Nil(t, conn.Snapshot(&buf))
+	snap := buf.Bytes()
+
+	// flip a byte in the value
+	corrupt := append([]byte{}, snap...)
+	corrupt[bytes.Index(corrupt, []byte("data"))] ^= 0xff
+	_, err = c.OpenFromSnapshot(bytes.NewReader(corrupt))
+	assert.Equal(t, ErrSnapshotChecksum, err)
+
+	// truncated
+	_, err = c.OpenFromSnapshot(bytes.NewReader(snap[:len(snap)-6]))
+	assert.Equal(t, ErrInvalidSnapshot, err)
+
+	// bad header
+	_, err = c.OpenFromSnapshot(bytes.NewReader([]byte("nope!")))
+	assert.Equal(t, ErrInvalidSnapshot, err)
+
+	// unknown version
+	future := append([]byte{}, snap...)
+	future[len(snapshotMagic)] = snapshotVersion + 1
+	_, err = c.OpenFromSnapshot(bytes.NewReader(future))
+	assert.Equal(t, ErrSnapshotVersion, err)
+}
+
+func TestSnapshotOversizedLength(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+
+	header := snapshotMagic + string([]byte{snapshotVersion, recordEntry})
+	huge := strings.Repeat("\xff", 9) + "\x01" // a uvarint above MaxInt64
+	big := "\x80\x80\x80\x80\x10"              // 1<<32
+	for name, input := range map[string]string{
+		"key over MaxInt64":   header + huge,
+		"key over MaxInt32":   header + big,
+		"key past the input":  header + "\x80\x01key",
+		"value over MaxInt64": header + "\x03key" + huge,
+		"value over MaxInt32": header + "\x03key" + big,
+		"tag over MaxInt64":   header + "\x03key\x04data\x00\x00\x00\x00\x00\x01" + huge,
+	} {
+		// a corrupt length must fail rather than panic or allocate its full size
+		_, err := c.OpenFromSnapshot(strings.NewReader(input))
+		assert.Equal(t, ErrInvalidSnapshot, err, name)
+
+		conn, err := c.Open("")
+		assert.Nil(t, err)
+		assert.Equal(t, ErrInvalidSnapshot, conn.Restore(strings.NewReader(input)), name)
+		conn.Close()
+	}
+}
+
+func TestRestore(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1}))
+	assert.Nil(t, conn.Write([]byte("b"), []byte{2}))
+	buf := bytes.Buffer{}
+	assert.Nil(t, conn.Snapshot(&buf))
+	snap := buf.Bytes()
+
+	other, err := c.Open("")
+	assert.Nil(t, err)
+	defer other.Close()
+	assert.Nil(t, other.Write([]byte("a"), []byte{9}))
+	assert.Nil(t, other.Write([]byte("c"), []byte{3}))
+
+	// a corrupt snapshot leaves the connection untouched
+	corrupt := append([]byte{}, snap...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	assert.Equal(t, ErrSnapshotChecksum, other.Restore(bytes.NewReader(corrupt)))
+	b, err := other.Read([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{9}, b)
+	assert.False(t, other.Exists([]byte("b")))
+
+	assert.Nil(t, other.Restore(bytes.NewReader(snap)))
+	b, err = other.Read([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	assert.True(t, other.Exists([]byte("b")))
+	assert.True(t, other.Exists([]byte("c")))
+}
+
+func TestSnapshotVersion1(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+
+	// a version 1 element is a current element without the trailing tag count and flags
+	var el bytes.Buffer
+	encodeElement(&el, "key", newElement([]byte("data"), time.Now(), 0, time.Hour))
+	h := crc32.NewIEEE()
+	snap := bytes.Buffer{}
+	w := io.MultiWriter(&snap, h)
+	w.Write([]byte(snapshotMagic + "\x01"))
+	w.Write([]byte{recordEntry})
+	w.Write(el.Bytes()[:el.Len()-2])
+	w.Write([]byte{recordEnd, 1})
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], h.Sum32())
+	snap.Write(sum[:])
+
+	conn, err := c.OpenFromSnapshot(&snap)
+	assert.Nil(t, err)
+	defer conn.Close()
+	b, err := conn.Read([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), b)
+}
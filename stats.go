@@ -0,0 +1,191 @@
+package memorystorecache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats displays stats about the memory store
+type Stats map[string]interface{}
+
+// counters are the connection's atomically updated statistics
+type counters struct {
+	hits          uint64
+	misses        uint64
+	expiredOnRead uint64
+	writes        uint64
+	deletes       uint64
+	sweepRuns     uint64
+	sweepRemoved  uint64
+
+	evictedBytes   uint64
+	evictedEntries uint64
+
+	loaderCalls    uint64
+	loaderErrors   uint64
+	loaderWaits    uint64
+	loaderNanos    uint64
+	negativeHits   uint64
+	staleHits      uint64
+	earlyRefreshes uint64
+	refreshes      uint64
+	refreshErrors  uint64
+}
+
+// ConnStats is a typed view of a connection's statistics
+type ConnStats struct {
+	KeyCount uint64
+	Bytes    int64 // bytes of keys and values stored
+
+	Hits          uint64
+	Misses        uint64 // includes ExpiredOnRead
+	ExpiredOnRead uint64
+	Writes        uint64
+	Deletes       uint64
+	SweepRuns     uint64
+	SweepRemoved  uint64 // expired keys removed by sweeps
+
+	EvictionsMaxBytes     uint64
+	EvictionsMaxEntries   uint64
+	EvictCallbacksDropped uint64 // entries not passed to OnEvict because its queue was full
+
+	LoaderCalls        uint64
+	LoaderErrors       uint64
+	LoaderCoalesced    uint64 // GetOrLoad calls that waited on another call's load
+	LoaderNegativeHits uint64
+	LoaderLatencyAvg   time.Duration
+	StaleHits          uint64
+	EarlyRefreshes     uint64
+	Refreshes          uint64
+	RefreshErrors      uint64
+
+	WriteBehindQueued   uint64 // changes waiting to be flushed to the Store
+	WriteBehindFlushed  uint64
+	WriteBehindFailures uint64 // failed Store.WriteBatch calls
+
+	Shards []ShardStats
+}
+
+// ShardStats describes a single shard, to spot keys skewed onto a few shards
+type ShardStats struct {
+	KeyCount uint64
+	Bytes    int64
+}
+
+// Observer receives the latency of cache operations, for example to export histograms
+// it is called synchronously, so implementations must be fast and safe for concurrent use
+type Observer interface {
+	// ObserveRead is called after every Read
+	ObserveRead(d time.Duration, hit bool)
+	// ObserveWrite is called after every Write, WriteTTL and MultiWrite
+	ObserveWrite(d time.Duration)
+	// ObserveSweep is called after every garbage collection sweep
+	ObserveSweep(d time.Duration, removed uint64)
+}
+
+// observerBox lets a nil Observer be stored in an atomic.Value
+type observerBox struct {
+	o Observer
+}
+
+// SetObserver sets the Observer for the connection, nil removes it
+func (c *Conn) SetObserver(o Observer) {
+	c.observer.Store(observerBox{o})
+}
+
+func (c *Conn) loadObserver() Observer {
+	b, _ := c.observer.Load().(observerBox)
+	return b.o
+}
+
+// keyCount counts the keys in every shard, including expired keys not yet removed
+func (c *Conn) keyCount() uint64 {
+	var x uint64
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		x += uint64(len(s.dat))
+		s.mu.RUnlock()
+	}
+	return x
+}
+
+// TypedStats provides stats about the memory store as a struct
+func (c *Conn) TypedStats() ConnStats {
+	st := ConnStats{
+		Hits:          atomic.LoadUint64(&c.stats.hits),
+		Misses:        atomic.LoadUint64(&c.stats.misses),
+		ExpiredOnRead: atomic.LoadUint64(&c.stats.expiredOnRead),
+		Writes:        atomic.LoadUint64(&c.stats.writes),
+		Deletes:       atomic.LoadUint64(&c.stats.deletes),
+		SweepRuns:     atomic.LoadUint64(&c.stats.sweepRuns),
+		SweepRemoved:  atomic.LoadUint64(&c.stats.sweepRemoved),
+
+		EvictionsMaxBytes:   atomic.LoadUint64(&c.stats.evictedBytes),
+		EvictionsMaxEntries: atomic.LoadUint64(&c.stats.evictedEntries),
+
+		LoaderCalls:        atomic.LoadUint64(&c.stats.loaderCalls),
+		LoaderErrors:       atomic.LoadUint64(&c.stats.loaderErrors),
+		LoaderCoalesced:    atomic.LoadUint64(&c.stats.loaderWaits),
+		LoaderNegativeHits: atomic.LoadUint64(&c.stats.negativeHits),
+		StaleHits:          atomic.LoadUint64(&c.stats.staleHits),
+		EarlyRefreshes:     atomic.LoadUint64(&c.stats.earlyRefreshes),
+		Refreshes:          atomic.LoadUint64(&c.stats.refreshes),
+		RefreshErrors:      atomic.LoadUint64(&c.stats.refreshErrors),
+
+		Shards: make([]ShardStats, len(c.shards)),
+	}
+	if c.evictions != nil {
+		st.EvictCallbacksDropped = atomic.LoadUint64(&c.evictions.dropped)
+	}
+	if c.writeBehind != nil {
+		st.WriteBehindQueued = uint64(c.writeBehind.queued())
+		st.WriteBehindFlushed = atomic.LoadUint64(&c.writeBehind.flushed)
+		st.WriteBehindFailures = atomic.LoadUint64(&c.writeBehind.failures)
+	}
+	if st.LoaderCalls > 0 {
+		st.LoaderLatencyAvg = time.Duration(atomic.LoadUint64(&c.stats.loaderNanos) / st.LoaderCalls)
+	}
+
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		st.Shards[i] = ShardStats{KeyCount: uint64(len(s.dat)), Bytes: s.bytes}
+		s.mu.RUnlock()
+		st.KeyCount += st.Shards[i].KeyCount
+		st.Bytes += st.Shards[i].Bytes
+	}
+	return st
+}
+
+// Stats provides stats about the memory store
+func (c *Conn) Stats() (map[string]interface{}, error) {
+	st := c.TypedStats()
+	return Stats{
+		"KeyCount":              st.KeyCount,
+		"Bytes":                 st.Bytes,
+		"Hits":                  st.Hits,
+		"Misses":                st.Misses,
+		"ExpiredOnRead":         st.ExpiredOnRead,
+		"Writes":                st.Writes,
+		"Deletes":               st.Deletes,
+		"SweepRuns":             st.SweepRuns,
+		"SweepRemoved":          st.SweepRemoved,
+		"EvictionsMaxBytes":     st.EvictionsMaxBytes,
+		"EvictionsMaxEntries":   st.EvictionsMaxEntries,
+		"EvictCallbacksDropped": st.EvictCallbacksDropped,
+		"LoaderCalls":           st.LoaderCalls,
+		"LoaderErrors":          st.LoaderErrors,
+		"LoaderCoalesced":       st.LoaderCoalesced,
+		"LoaderNegativeHits":    st.LoaderNegativeHits,
+		"LoaderLatencyAvg":      st.LoaderLatencyAvg,
+		"StaleHits":             st.StaleHits,
+		"EarlyRefreshes":        st.EarlyRefreshes,
+		"Refreshes":             st.Refreshes,
+		"RefreshErrors":         st.RefreshErrors,
+		"WriteBehindQueued":     st.WriteBehindQueued,
+		"WriteBehindFlushed":    st.WriteBehindFlushed,
+		"WriteBehindFailures":   st.WriteBehindFailures,
+		"Shards":                st.Shards,
+	}, nil
+}
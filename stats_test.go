@@ -0,0 +1,132 @@
+package memorystorecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	c, err := NewCache(time.Second, time.Second, WithShards(2))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// write a key
+	key := []byte("my-key")
+	v := []byte{1, 2}
+	err = conn.Write(key, v)
+	assert.Nil(t, err)
+
+	s, err := conn.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["KeyCount"])
+	assert.Equal(t, int64(8), s["Bytes"])
+	assert.Equal(t, uint64(1), s["Writes"])
+	assert.Equal(t, uint64(0), s["EvictionsMaxBytes"])
+	assert.Equal(t, time.Duration(0), s["LoaderLatencyAvg"])
+	assert.Len(t, s["Shards"], 2)
+
+	// every typed stat is in the map
+	assert.Len(t, s, 25)
+}
+
+func TestTypedStats(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, 0, WithShards(4), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.Write([]byte("a"), []byte{1, 2, 3}))
+	assert.Nil(t, conn.WriteTTL([]byte("b"), []byte{1}, time.Millisecond))
+	assert.Nil(t, conn.Write([]byte("c"), []byte{1}))
+	assert.Nil(t, conn.Delete([]byte("c")))
+	clk.Advance(time.Millisecond)
+
+	_, err = conn.Read([]byte("a"))
+	assert.Nil(t, err)
+	_, err = conn.Read([]byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = conn.Read([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	conn.sweep()
+	waitFor(t, func() bool {
+		return conn.TypedStats().SweepRemoved == 1
+	})
+
+	st := conn.TypedStats()
+	assert.Equal(t, uint64(1), st.KeyCount)
+	assert.Equal(t, int64(4), st.Bytes)
+	assert.Equal(t, uint64(1), st.Hits)
+	assert.Equal(t, uint64(2), st.Misses)
+	assert.Equal(t, uint64(1), st.ExpiredOnRead)
+	assert.Equal(t, uint64(3), st.Writes)
+	assert.Equal(t, uint64(1), st.Deletes)
+	assert.Equal(t, uint64(1), st.SweepRuns)
+
+	// the per shard breakdown adds up
+	assert.Len(t, st.Shards, 4)
+	var keys uint64
+	var bytes int64
+	for _, sh := range st.Shards {
+		keys += sh.KeyCount
+		bytes += sh.Bytes
+	}
+	assert.Equal(t, st.KeyCount, keys)
+	assert.Equal(t, st.Bytes, bytes)
+}
+
+type testObserver struct {
+	reads, hits, writes, sweeps int
+	removed                     uint64
+}
+
+func (o *testObserver) ObserveRead(d time.Duration, hit bool) {
+	o.reads++
+	if hit {
+		o.hits++
+	}
+}
+
+func (o *testObserver) ObserveWrite(d time.Duration) {
+	o.writes++
+}
+
+func (o *testObserver) ObserveSweep(d time.Duration, removed uint64) {
+	o.sweeps++
+	o.removed += removed
+}
+
+func TestObserver(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Second, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	o := &testObserver{}
+	conn.SetObserver(o)
+
+	assert.Nil(t, conn.WriteTTL([]byte("a"), []byte{1}, time.Millisecond))
+	assert.Nil(t, conn.Write([]byte("b"), []byte{1}))
+	_, err = conn.Read([]byte("b"))
+	assert.Nil(t, err)
+	_, err = conn.Read([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	clk.Advance(time.Millisecond)
+	conn.sweep()
+
+	assert.Equal(t, &testObserver{reads: 2, hits: 1, writes: 2, sweeps: 1, removed: 1}, o)
+
+	// removing the observer stops observations
+	conn.SetObserver(nil)
+	_, err = conn.Read([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, o.reads)
+}
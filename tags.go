@@ -0,0 +1,98 @@
+package memorystorecache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// tags group keys so they can be removed together with InvalidateTag
+// a key's tags are replaced by every write, so writing it with Write or WriteTTL drops them,
+// while Touch, TouchTTL and counters written with KeepTTL keep them
+
+// WriteWithTags writes data to the cache with the default TTL, tagging the key
+func (c *Conn) WriteWithTags(k, v []byte, tags ...string) error {
+	return c.WriteTTLWithTags(k, v, c.TTL, tags...)
+}
+
+// WriteTTLWithTags writes data to the cache with an explicit TTL, tagging the key
+func (c *Conn) WriteTTLWithTags(k, v []byte, ttl time.Duration, tags ...string) error {
+	el := newElement(v, c.now(), 0, ttl)
+	el.tags = uniqueTags(tags)
+	return c.writeElement(string(k), el)
+}
+
+// InvalidateTag removes every key carrying tag, returning how many unexpired keys it removed
+func (c *Conn) InvalidateTag(tag string) (int, error) {
+	t := c.now()
+	var n int
+	var err error
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.reapLocked(t)
+		for key := range s.tags[tag] {
+			el := s.dat[key]
+			s.removeLocked(key, EvictDeleted)
+			if el.expired(t) {
+				continue
+			}
+			n++
+			atomic.AddUint64(&c.stats.deletes, 1)
+			if lerr := c.logDelete(key); lerr != nil && err == nil {
+				err = lerr
+			}
+		}
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+// uniqueTags drops repeated tags, keeping the first of each
+func uniqueTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		seen := false
+		for _, o := range out {
+			if o == tag {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// tagLocked adds a key to the index of each of its element's tags, the caller must hold the write lock
+func (s *shard) tagLocked(key string, el cacheElement) {
+	if len(el.tags) == 0 {
+		return
+	}
+	if s.tags == nil {
+		s.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range el.tags {
+		keys, ok := s.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// untagLocked removes a key from the index of each of its element's tags, the caller must hold the write lock
+func (s *shard) untagLocked(key string, el cacheElement) {
+	for _, tag := range el.tags {
+		keys := s.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
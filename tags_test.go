@@ -0,0 +1,144 @@
+package memorystorecache
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.WriteWithTags([]byte("a"), []byte{1}, "user:1", "user:1", "posts"))
+	assert.Nil(t, conn.WriteTTLWithTags([]byte("b"), []byte{2}, time.Hour, "user:1"))
+	assert.Nil(t, conn.WriteWithTags([]byte("c"), []byte{3}, "posts"))
+	assert.Nil(t, conn.Write([]byte("d"), []byte{4}))
+
+	n, err := conn.InvalidateTag("user:1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.False(t, conn.Exists([]byte("a")))
+	assert.False(t, conn.Exists([]byte("b")))
+	assert.True(t, conn.Exists([]byte("c")))
+	assert.True(t, conn.Exists([]byte("d")))
+
+	n, err = conn.InvalidateTag("user:1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// a plain write drops a key's tags
+	assert.Nil(t, conn.Write([]byte("c"), []byte{5}))
+	n, err = conn.InvalidateTag("posts")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, conn.Exists([]byte("c")))
+
+	for i := range conn.shards {
+		assert.Empty(t, conn.shards[i].tags)
+	}
+}
+
+func TestTagsKeptByTouchAndIncr(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.WriteWithTags([]byte("a"), []byte("1"), "t"))
+	assert.Nil(t, conn.TouchTTL([]byte("a"), time.Hour))
+	_, err = conn.Incr([]byte("a"), 1, KeepTTL)
+	assert.Nil(t, err)
+
+	n, err := conn.InvalidateTag("t")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestTagsExpired(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithShards(1), WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+	s := &conn.shards[0]
+
+	// an expired key found by Read leaves the index with the next write to its shard
+	assert.Nil(t, conn.WriteTTLWithTags([]byte("a"), []byte{1}, time.Second, "t"))
+	clk.Advance(time.Second)
+	_, err = conn.Read([]byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, conn.Write([]byte("b"), []byte{2}))
+	assert.Empty(t, s.tags)
+
+	// and through a sweep
+	assert.Nil(t, conn.WriteTTLWithTags([]byte("c"), []byte{3}, time.Second, "t"))
+	clk.Advance(time.Second)
+	conn.sweep()
+	assert.Empty(t, s.tags)
+
+	// expired keys are not counted as invalidated
+	assert.Nil(t, conn.WriteTTLWithTags([]byte("d"), []byte{4}, time.Second, "t"))
+	clk.Advance(time.Second)
+	n, err := conn.InvalidateTag("t")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Empty(t, s.tags)
+}
+
+func TestTagsEvicted(t *testing.T) {
+	c, err := NewCache(0, 0, WithShards(1), WithMaxEntries(1))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.WriteWithTags([]byte("a"), []byte{1}, "t"))
+	assert.Nil(t, conn.WriteWithTags([]byte("b"), []byte{2}, "u"))
+	assert.False(t, conn.Exists([]byte("a")))
+	s := &conn.shards[0]
+	assert.Len(t, s.tags, 1)
+	assert.Len(t, s.tags["u"], 1)
+}
+
+func TestTagsPersisted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(time.Minute, 0, WithAppendLog(FsyncAlways))
+	assert.Nil(t, err)
+	conn, err := c.Open(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, conn.WriteWithTags([]byte("a"), []byte{1}, "t", "u"))
+	assert.Nil(t, conn.WriteWithTags([]byte("b"), []byte{2}, "u"))
+
+	buf := bytes.Buffer{}
+	assert.Nil(t, conn.Snapshot(&buf))
+	assert.Nil(t, conn.Close())
+
+	mem, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	restored, err := mem.OpenFromSnapshot(&buf)
+	assert.Nil(t, err)
+	defer restored.Close()
+	n, err := restored.InvalidateTag("u")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	// the append log is replayed with its tags
+	conn, err = c.Open(dir)
+	assert.Nil(t, err)
+	defer conn.Close()
+	n, err = conn.InvalidateTag("t")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, conn.Exists([]byte("b")))
+}
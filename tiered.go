@@ -0,0 +1,179 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is a slower, usually shared, cache connection a TieredConn reads and writes through to
+// it is the method set of an omni-cache connection, which Conn and TieredConn also implement
+type Backend interface {
+	Read(k []byte) ([]byte, error)
+	Write(k, v []byte) error
+	WriteTTL(k, v []byte, ttl time.Duration) error
+	Stats() (map[string]interface{}, error)
+	Close() error
+}
+
+var (
+	_ Backend = (*Conn)(nil)
+	_ Backend = (*TieredConn)(nil)
+)
+
+// TieredConn keeps a memory store connection (L1) in front of a Backend (L2)
+// reads try L1, then L2, copying L2 hits into L1; writes go to L2, then L1
+// L1 copies live at most the fill TTL, so writes other processes make to L2 are seen within it
+type TieredConn struct {
+	stats tierCounters
+
+	l1      *Conn
+	l2      Backend
+	fillTTL time.Duration
+}
+
+// tierCounters are a TieredConn's atomically updated statistics
+type tierCounters struct {
+	l1Hits   uint64
+	l2Hits   uint64
+	misses   uint64
+	l2Writes uint64
+	l2Errors uint64
+}
+
+// TierStats is a typed view of a TieredConn's statistics
+type TierStats struct {
+	L1Hits   uint64 // includes reads that waited on a concurrent read's L2 fetch
+	L2Hits   uint64
+	Misses   uint64 // reads neither tier could answer, including L2 errors
+	L2Writes uint64
+	L2Errors uint64 // failed L2 writes
+}
+
+// NewTieredConn creates a TieredConn reading l1, then l2
+// fillTTL is how long L1 keeps values read from or written to L2, and must be positive
+// concurrent L1 misses for a key share a single L2 read, see GetOrLoad
+func NewTieredConn(l1 *Conn, l2 Backend, fillTTL time.Duration) (*TieredConn, error) {
+	if l1 == nil || l2 == nil {
+		return nil, errors.New("both tiers are required")
+	}
+	if fillTTL <= 0 {
+		return nil, errors.New("fill TTL must be positive")
+	}
+	return &TieredConn{l1: l1, l2: l2, fillTTL: fillTTL}, nil
+}
+
+// L1 returns the memory store in front of the backend
+func (t *TieredConn) L1() *Conn {
+	return t.l1
+}
+
+// L2 returns the backend
+func (t *TieredConn) L2() Backend {
+	return t.l2
+}
+
+// Read retrieves data for a key from L1, or from L2 on an L1 miss
+func (t *TieredConn) Read(k []byte) ([]byte, error) {
+	fromL2 := false
+	v, err := t.l1.GetOrLoad(k, t.fillTTL, func() ([]byte, error) {
+		fromL2 = true
+		return t.l2.Read(k)
+	})
+	switch {
+	case err != nil:
+		atomic.AddUint64(&t.stats.misses, 1)
+	case fromL2:
+		atomic.AddUint64(&t.stats.l2Hits, 1)
+	default:
+		atomic.AddUint64(&t.stats.l1Hits, 1)
+	}
+	return v, err
+}
+
+// Write writes data to L2 with its default TTL, then to L1
+func (t *TieredConn) Write(k, v []byte) error {
+	if err := t.writeL2(t.l2.Write(k, v)); err != nil {
+		return err
+	}
+	return t.l1.WriteTTL(k, v, t.fillTTL)
+}
+
+// WriteTTL writes data to L2 with an explicit TTL, then to L1 for at most the fill TTL
+// L1 is left untouched if the L2 write fails
+func (t *TieredConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	if err := t.writeL2(t.l2.WriteTTL(k, v, ttl)); err != nil {
+		return err
+	}
+	if ttl == 0 || ttl > t.fillTTL {
+		ttl = t.fillTTL
+	}
+	return t.l1.WriteTTL(k, v, ttl)
+}
+
+// writeL2 counts the result of an L2 write
+func (t *TieredConn) writeL2(err error) error {
+	atomic.AddUint64(&t.stats.l2Writes, 1)
+	if err != nil {
+		atomic.AddUint64(&t.stats.l2Errors, 1)
+	}
+	return err
+}
+
+// Delete removes a key from L1, and from L2 if it has a Delete(k []byte) error method
+// ErrKeyNotFound from L1 is ignored, as the key may only be in L2
+func (t *TieredConn) Delete(k []byte) error {
+	if d, ok := t.l2.(interface {
+		Delete(k []byte) error
+	}); ok {
+		if err := d.Delete(k); err != nil && err != ErrKeyNotFound {
+			return err
+		}
+	}
+	if err := t.l1.Delete(k); err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// TypedStats returns the tier statistics as a struct
+func (t *TieredConn) TypedStats() TierStats {
+	return TierStats{
+		L1Hits:   atomic.LoadUint64(&t.stats.l1Hits),
+		L2Hits:   atomic.LoadUint64(&t.stats.l2Hits),
+		Misses:   atomic.LoadUint64(&t.stats.misses),
+		L2Writes: atomic.LoadUint64(&t.stats.l2Writes),
+		L2Errors: atomic.LoadUint64(&t.stats.l2Errors),
+	}
+}
+
+// Stats provides the tier statistics along with the stats of each tier under "L1" and "L2"
+func (t *TieredConn) Stats() (map[string]interface{}, error) {
+	l1, err := t.l1.Stats()
+	if err != nil {
+		return nil, err
+	}
+	l2, err := t.l2.Stats()
+	if err != nil {
+		return nil, err
+	}
+	st := t.TypedStats()
+	return Stats{
+		"L1Hits":   st.L1Hits,
+		"L2Hits":   st.L2Hits,
+		"Misses":   st.Misses,
+		"L2Writes": st.L2Writes,
+		"L2Errors": st.L2Errors,
+		"L1":       l1,
+		"L2":       l2,
+	}, nil
+}
+
+// Close closes both tiers, returning the first error
+func (t *TieredConn) Close() error {
+	err := t.l1.Close()
+	if err2 := t.l2.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBackend is an in memory L2 that records its calls
+type fakeBackend struct {
+	mu       sync.Mutex
+	dat      map[string][]byte
+	ttls     map[string]time.Duration
+	reads    int
+	writeErr error
+	closed   bool
+	// block, if set, holds reads until it is closed
+	block chan struct{}
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{dat: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (b *fakeBackend) Read(k []byte) ([]byte, error) {
+	if b.block != nil {
+		<-b.block
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads++
+	v, ok := b.dat[string(k)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (b *fakeBackend) Write(k, v []byte) error {
+	return b.WriteTTL(k, v, -1)
+}
+
+func (b *fakeBackend) WriteTTL(k, v []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.writeErr != nil {
+		return b.writeErr
+	}
+	b.dat[string(k)] = v
+	b.ttls[string(k)] = ttl
+	return nil
+}
+
+func (b *fakeBackend) Delete(k []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.dat, string(k))
+	return nil
+}
+
+func (b *fakeBackend) Stats() (map[string]interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{"Reads": b.reads}, nil
+}
+
+func (b *fakeBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func newTestTiered(t *testing.T, clk Clock) (*TieredConn, *fakeBackend) {
+	c, err := NewCache(time.Hour, 0, WithClock(clk))
+	assert.Nil(t, err)
+	l1, err := c.Open("")
+	assert.Nil(t, err)
+	l2 := newFakeBackend()
+	tc, err := NewTieredConn(l1, l2, time.Minute)
+	assert.Nil(t, err)
+	return tc, l2
+}
+
+func TestTieredRead(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	tc, l2 := newTestTiered(t, clk)
+	defer tc.Close()
+
+	l2.dat["key"] = []byte("data")
+	_, err := tc.Read([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// an L2 hit fills L1 for the fill TTL
+	v, err := tc.Read([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), v)
+	ttl, err := tc.L1().RemainingTTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	v, err = tc.Read([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), v)
+	assert.Equal(t, 2, l2.reads)
+
+	// once the L1 copy expires, L2 is read again
+	clk.Advance(time.Minute)
+	_, err = tc.Read([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, l2.reads)
+
+	assert.Equal(t, TierStats{L1Hits: 1, L2Hits: 2, Misses: 1}, tc.TypedStats())
+	s, err := tc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), s["L2Hits"])
+	assert.Equal(t, 3, s["L2"].(map[string]interface{})["Reads"])
+}
+
+func TestTieredReadCoalesced(t *testing.T) {
+	tc, l2 := newTestTiered(t, NewFakeClock(time.Now()))
+	defer tc.Close()
+	l2.dat["key"] = []byte("data")
+	l2.block = make(chan struct{})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := tc.Read([]byte("key"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("data"), v)
+		}()
+	}
+	waitFor(t, func() bool { return tc.l1.loads.waiting("key") == 9 })
+	close(l2.block)
+	wg.Wait()
+
+	// readers that missed L1 together share one L2 read
+	l2.mu.Lock()
+	assert.Equal(t, 1, l2.reads)
+	l2.mu.Unlock()
+	st := tc.TypedStats()
+	assert.Equal(t, uint64(10), st.L1Hits+st.L2Hits)
+}
+
+func TestTieredWrite(t *testing.T) {
+	tc, l2 := newTestTiered(t, NewFakeClock(time.Now()))
+
+	assert.Nil(t, tc.WriteTTL([]byte("a"), []byte{1}, time.Hour))
+	assert.Nil(t, tc.WriteTTL([]byte("b"), []byte{2}, time.Second))
+	assert.Nil(t, tc.Write([]byte("c"), []byte{3}))
+
+	// L2 gets the TTL asked for, L1 at most the fill TTL
+	assert.Equal(t, time.Hour, l2.ttls["a"])
+	assert.Equal(t, time.Duration(-1), l2.ttls["c"])
+	for key, want := range map[string]time.Duration{"a": time.Minute, "b": time.Second, "c": time.Minute} {
+		ttl, err := tc.L1().RemainingTTL([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, want, ttl, key)
+	}
+
+	// a failed L2 write leaves L1 alone
+	l2.writeErr = errors.New("backend down")
+	assert.Equal(t, l2.writeErr, tc.WriteTTL([]byte("d"), []byte{4}, time.Hour))
+	assert.False(t, tc.L1().Exists([]byte("d")))
+	assert.Equal(t, uint64(4), tc.TypedStats().L2Writes)
+	assert.Equal(t, uint64(1), tc.TypedStats().L2Errors)
+
+	assert.Nil(t, tc.Delete([]byte("a")))
+	assert.False(t, tc.L1().Exists([]byte("a")))
+	_, ok := l2.dat["a"]
+	assert.False(t, ok)
+	assert.Nil(t, tc.Delete([]byte("missing")))
+
+	assert.Nil(t, tc.Close())
+	assert.True(t, l2.closed)
+}
+
+func TestNewTieredConnValidation(t *testing.T) {
+	c, err := NewCache(time.Hour, 0)
+	assert.Nil(t, err)
+	l1, err := c.Open("")
+	assert.Nil(t, err)
+	defer l1.Close()
+
+	_, err = NewTieredConn(l1, nil, time.Minute)
+	assert.NotNil(t, err)
+	_, err = NewTieredConn(l1, newFakeBackend(), 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrKeyExists is returned by WriteIfAbsent when the key is already in the cache
+	ErrKeyExists = errors.New("Key already exists")
+	// ErrVersionMismatch is returned when a key's version is not the expected one
+	ErrVersionMismatch = errors.New("Version mismatch")
+)
+
+// versions identify a single write of a key, every write gives the key a new, larger version
+// they start at the time the connection was opened, so they are not reused after a restart
+// Touch and TouchTTL keep a key's version
+
+// ReadVersion retrieves data for a key along with its version
+func (c *Conn) ReadVersion(k []byte) ([]byte, uint64, error) {
+	el, err := c.readElement(k)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	return el.dat, el.version, nil
+}
+
+// ReadFlags retrieves data for a key along with its flags and version
+func (c *Conn) ReadFlags(k []byte) ([]byte, uint32, uint64, error) {
+	el, err := c.readElement(k)
+	if err != nil {
+		return []byte{}, 0, 0, err
+	}
+	return el.dat, el.flags, el.version, nil
+}
+
+// WriteOption sets metadata stored alongside a value by the versioned writes
+type WriteOption func(el *cacheElement)
+
+// WriteFlags stores opaque client flags with a value, such as the flags of memcached clients, read back with ReadFlags
+// writes without flags clear them, while Touch, TouchTTL and the counters keep them
+func WriteFlags(flags uint32) WriteOption {
+	return func(el *cacheElement) {
+		el.flags = flags
+	}
+}
+
+// newVersionedElement builds the element of a versioned write
+func (c *Conn) newVersionedElement(v []byte, ttl time.Duration, opts []WriteOption) cacheElement {
+	el := newElement(v, c.now(), 0, ttl)
+	for _, opt := range opts {
+		opt(&el)
+	}
+	return el
+}
+
+// WriteVersion writes data to the cache with an explicit TTL, returning the key's new version
+func (c *Conn) WriteVersion(k, v []byte, ttl time.Duration, opts ...WriteOption) (uint64, error) {
+	return c.writeElementIf(string(k), c.newVersionedElement(v, ttl, opts), nil)
+}
+
+// CompareAndSwap writes data to the cache if the key's version is expectedVersion, returning its new version
+// ErrKeyNotFound is returned if the key is missing or expired, and ErrVersionMismatch if it was written since
+func (c *Conn) CompareAndSwap(k []byte, expectedVersion uint64, v []byte, ttl time.Duration, opts ...WriteOption) (uint64, error) {
+	return c.writeElementIf(string(k), c.newVersionedElement(v, ttl, opts), func(cur cacheElement, exists bool) error {
+		if !exists {
+			return ErrKeyNotFound
+		}
+		if cur.version != expectedVersion {
+			return ErrVersionMismatch
+		}
+		return nil
+	})
+}
+
+// WriteIfAbsent writes data to the cache if the key is missing or expired, returning its version
+// ErrKeyExists is returned otherwise
+func (c *Conn) WriteIfAbsent(k, v []byte, ttl time.Duration, opts ...WriteOption) (uint64, error) {
+	return c.writeElementIf(string(k), c.newVersionedElement(v, ttl, opts), func(cur cacheElement, exists bool) error {
+		if exists {
+			return ErrKeyExists
+		}
+		return nil
+	})
+}
+
+// Replace writes data to the cache if the key is already there, returning its new version
+// ErrKeyNotFound is returned if the key is missing or expired
+func (c *Conn) Replace(k, v []byte, ttl time.Duration, opts ...WriteOption) (uint64, error) {
+	return c.writeElementIf(string(k), c.newVersionedElement(v, ttl, opts), func(cur cacheElement, exists bool) error {
+		if !exists {
+			return ErrKeyNotFound
+		}
+		return nil
+	})
+}
+
+// CompareAndDelete removes a key if its version is expectedVersion
+// ErrKeyNotFound is returned if the key is missing or expired, and ErrVersionMismatch if it was written since
+func (c *Conn) CompareAndDelete(k []byte, expectedVersion uint64) error {
+	key := string(k)
+	s := c.shardFor(key)
+	t := c.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reapLocked(t)
+	el, exists := s.dat[key]
+	if !exists || el.expired(t) {
+		return ErrKeyNotFound
+	}
+	if el.version != expectedVersion {
+		return ErrVersionMismatch
+	}
+	s.removeLocked(key, EvictDeleted)
+	s.markDeletedLocked(key)
+	atomic.AddUint64(&c.stats.deletes, 1)
+	return c.logDelete(key)
+}
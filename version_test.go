@@ -0,0 +1,205 @@
+package memorystorecache
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadVersion(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, _, err = conn.ReadVersion([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, conn.Write([]byte("key"), []byte{1}))
+	v, v1, err := conn.ReadVersion([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+
+	// touching keeps the version, writing changes it
+	assert.Nil(t, conn.Touch([]byte("key")))
+	_, v2, err := conn.ReadVersion([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, v1, v2)
+
+	v3, err := conn.WriteVersion([]byte("key"), []byte{2}, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, v3 > v1)
+	_, v4, err := conn.ReadVersion([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, v3, v4)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	_, err = conn.CompareAndSwap(key, 1, []byte{1}, time.Minute)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	v1, err := conn.WriteVersion(key, []byte{1}, time.Minute)
+	assert.Nil(t, err)
+	v2, err := conn.CompareAndSwap(key, v1, []byte{2}, time.Minute)
+	assert.Nil(t, err)
+	_, err = conn.CompareAndSwap(key, v1, []byte{3}, time.Minute)
+	assert.Equal(t, ErrVersionMismatch, err)
+
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+
+	// an expired key cannot be swapped
+	clk.Advance(time.Minute)
+	_, err = conn.CompareAndSwap(key, v2, []byte{3}, time.Minute)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("counter")
+	assert.Nil(t, conn.Write(key, []byte{0}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				for {
+					v, ver, err := conn.ReadVersion(key)
+					assert.Nil(t, err)
+					if _, err := conn.CompareAndSwap(key, ver, []byte{v[0] + 1}, time.Minute); err == nil {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	// 800 increments wrap the byte around to 32
+	assert.Equal(t, []byte{32}, b)
+}
+
+func TestWriteIfAbsent(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c, err := NewCache(time.Minute, 0, WithClock(clk))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	_, err = conn.WriteIfAbsent(key, []byte{1}, time.Second)
+	assert.Nil(t, err)
+	_, err = conn.WriteIfAbsent(key, []byte{2}, time.Second)
+	assert.Equal(t, ErrKeyExists, err)
+
+	// an expired key counts as absent
+	clk.Advance(time.Second)
+	_, err = conn.WriteIfAbsent(key, []byte{3}, time.Second)
+	assert.Nil(t, err)
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, b)
+}
+
+func TestReplace(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	_, err = conn.Replace(key, []byte{1}, time.Minute)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.False(t, conn.Exists(key))
+
+	assert.Nil(t, conn.Write(key, []byte{1}))
+	_, err = conn.Replace(key, []byte{2}, time.Minute)
+	assert.Nil(t, err)
+	b, err := conn.Read(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	key := []byte("key")
+	assert.Equal(t, ErrKeyNotFound, conn.CompareAndDelete(key, 1))
+	ver, err := conn.WriteVersion(key, []byte{1}, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrVersionMismatch, conn.CompareAndDelete(key, ver+1))
+	assert.Nil(t, conn.CompareAndDelete(key, ver))
+	assert.False(t, conn.Exists(key))
+}
+
+func TestWriteFlags(t *testing.T) {
+	c, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	v1, err := conn.WriteVersion([]byte("key"), []byte("1"), time.Hour, WriteFlags(7))
+	assert.Nil(t, err)
+	v, flags, version, err := conn.ReadFlags([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), v)
+	assert.Equal(t, uint32(7), flags)
+	assert.Equal(t, v1, version)
+
+	// touches and counters keep the flags
+	assert.Nil(t, conn.TouchTTL([]byte("key"), time.Minute))
+	_, err = conn.Incr([]byte("key"), 1, time.Hour)
+	assert.Nil(t, err)
+	v, flags, _, err = conn.ReadFlags([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), v)
+	assert.Equal(t, uint32(7), flags)
+
+	// and they are kept by snapshots
+	var buf bytes.Buffer
+	assert.Nil(t, conn.Snapshot(&buf))
+	restored, err := c.OpenFromSnapshot(&buf)
+	assert.Nil(t, err)
+	defer restored.Close()
+	_, flags, _, err = restored.ReadFlags([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(7), flags)
+
+	// a plain write clears them
+	assert.Nil(t, conn.Write([]byte("key"), []byte("3")))
+	_, flags, _, err = conn.ReadFlags([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), flags)
+
+	_, _, _, err = conn.ReadFlags([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,286 @@
+package memorystorecache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store is a backing store written behind the cache, see WithWriteBehind
+type Store interface {
+	// WriteBatch persists changes to different keys
+	// a failed batch is retried, possibly merged with later changes, so applying a change twice must be safe
+	WriteBatch(changes []Change) error
+}
+
+// Change is a key written or deleted, waiting to be flushed to a Store
+type Change struct {
+	Key       []byte
+	Value     []byte
+	ExpiresAt time.Time // the zero time never expires
+	Deleted   bool
+}
+
+const (
+	defaultWriteBehindBatch = 100
+	defaultMinBackoff       = 100 * time.Millisecond
+	defaultMaxBackoff       = 30 * time.Second
+
+	// closeFlushAttempts is how many times Close tries each batch before giving up
+	closeFlushAttempts = 5
+)
+
+// WithWriteBehind flushes writes and deletes to store in the background, every interval
+// or as soon as a batch is full, so writes do not wait on the store
+// changes to a key that has not been flushed yet are coalesced into its latest value,
+// so at most one change per key is queued; Touch and TouchTTL queue the new expiry
+// Delete and CompareAndDelete are flushed as deletes, while keys that expire, are evicted,
+// or are removed by Flush, FlushNamespace or InvalidateTag are left in the store
+// only client writes are queued: values filled by GetOrLoad, background refreshes,
+// Restore, OpenFromSnapshot or append log replay came from elsewhere and are not written back
+// failed batches are retried with exponential backoff, and Close flushes what is left
+func WithWriteBehind(store Store, interval time.Duration) Option {
+	return func(c *Cache) error {
+		if store == nil {
+			return errors.New("store must not be nil")
+		}
+		if interval <= 0 {
+			return errors.New("write behind interval must be positive")
+		}
+		c.store = store
+		c.storeInterval = interval
+		return nil
+	}
+}
+
+// WithWriteBehindBatch sets the most changes passed to a single Store.WriteBatch call, 100 by default
+func WithWriteBehindBatch(n int) Option {
+	return func(c *Cache) error {
+		if n <= 0 {
+			return errors.New("write behind batch size must be positive")
+		}
+		c.storeBatch = n
+		return nil
+	}
+}
+
+// WithWriteBehindBackoff sets the wait after a failed flush, doubling from min up to max
+// it defaults to 100ms up to 30s
+func WithWriteBehindBackoff(min, max time.Duration) Option {
+	return func(c *Cache) error {
+		if min <= 0 || max < min {
+			return errors.New("write behind backoff must be positive and min must not exceed max")
+		}
+		c.storeMinBackoff = min
+		c.storeMaxBackoff = max
+		return nil
+	}
+}
+
+// writeBehind queues changes and flushes them to a Store from its own goroutine
+type writeBehind struct {
+	flushed  uint64
+	failures uint64
+
+	store      Store
+	clock      Clock
+	batch      int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// mu guards dirty and order, the keys of dirty from oldest to newest
+	mu    sync.Mutex
+	dirty map[string]Change
+	order []string
+
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newWriteBehind(c Cache, clock Clock) *writeBehind {
+	w := &writeBehind{
+		store:      c.store,
+		clock:      clock,
+		batch:      c.storeBatch,
+		minBackoff: c.storeMinBackoff,
+		maxBackoff: c.storeMaxBackoff,
+		dirty:      map[string]Change{},
+		kick:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if w.batch == 0 {
+		w.batch = defaultWriteBehindBatch
+	}
+	if w.minBackoff == 0 {
+		w.minBackoff = defaultMinBackoff
+		w.maxBackoff = defaultMaxBackoff
+	}
+	// the ticker is started before Open returns, so a FakeClock advanced straight after Open fires it
+	go w.run(clock.NewTicker(c.storeInterval))
+	return w
+}
+
+// markLocked queues the latest element of a key, the caller must hold the key's shard write lock
+// so a key's changes are queued in order
+func (s *shard) markLocked(key string, el cacheElement) {
+	if s.writeBehind != nil {
+		s.writeBehind.queue(Change{Key: []byte(key), Value: el.dat, ExpiresAt: el.expiresAt})
+	}
+}
+
+// markDeletedLocked queues the delete of a key, the caller must hold the key's shard write lock
+func (s *shard) markDeletedLocked(key string) {
+	if s.writeBehind != nil {
+		s.writeBehind.queue(Change{Key: []byte(key), Deleted: true})
+	}
+}
+
+// queue adds a change, replacing any change to the same key not yet flushed
+func (w *writeBehind) queue(ch Change) {
+	key := string(ch.Key)
+	w.mu.Lock()
+	if _, exists := w.dirty[key]; !exists {
+		w.order = append(w.order, key)
+	}
+	w.dirty[key] = ch
+	full := len(w.order) >= w.batch
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take removes up to a batch of the oldest changes from the queue
+func (w *writeBehind) take() []Change {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := minInt(w.batch, len(w.order))
+	if n == 0 {
+		return nil
+	}
+	changes := make([]Change, n)
+	for i, key := range w.order[:n] {
+		changes[i] = w.dirty[key]
+		delete(w.dirty, key)
+	}
+	w.order = append(w.order[:0], w.order[n:]...)
+	return changes
+}
+
+// requeue puts a failed batch back at the front of the queue
+// keys changed again since the batch was taken keep their newer change
+func (w *writeBehind) requeue(changes []Change) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	order := make([]string, 0, len(changes)+len(w.order))
+	for _, ch := range changes {
+		key := string(ch.Key)
+		if _, exists := w.dirty[key]; !exists {
+			w.dirty[key] = ch
+			order = append(order, key)
+		}
+	}
+	w.order = append(order, w.order...)
+}
+
+// queued returns how many changes are waiting to be flushed
+func (w *writeBehind) queued() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.order)
+}
+
+// flush writes a batch to the store, putting it back in the queue if that fails
+func (w *writeBehind) flush(changes []Change) error {
+	if err := w.store.WriteBatch(changes); err != nil {
+		atomic.AddUint64(&w.failures, 1)
+		w.requeue(changes)
+		return err
+	}
+	atomic.AddUint64(&w.flushed, uint64(len(changes)))
+	return nil
+}
+
+// nextBackoff doubles a backoff between the minimum and maximum
+func (w *writeBehind) nextBackoff(d time.Duration) time.Duration {
+	if d == 0 {
+		return w.minBackoff
+	}
+	if d *= 2; d > w.maxBackoff {
+		return w.maxBackoff
+	}
+	return d
+}
+
+// run flushes the queue every tick, or when a batch fills, until close
+func (w *writeBehind) run(ticker Ticker) {
+	defer close(w.stopped)
+	defer ticker.Stop()
+
+	var backoff time.Duration
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C():
+		case <-w.kick:
+		}
+
+		for changes := w.take(); len(changes) > 0; changes = w.take() {
+			if err := w.flush(changes); err == nil {
+				backoff = 0
+				continue
+			}
+			backoff = w.nextBackoff(backoff)
+			if !w.wait(backoff) {
+				return
+			}
+		}
+	}
+}
+
+// wait sleeps for d on the clock, returning false if the queue is closed first
+func (w *writeBehind) wait(d time.Duration) bool {
+	t := w.clock.NewTicker(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// close stops the background flushes and flushes every queued change
+// each batch is tried closeFlushAttempts times, waiting in real time between attempts,
+// and the store's last error is returned if changes are left unflushed
+func (w *writeBehind) close() error {
+	close(w.done)
+	<-w.stopped
+
+	for changes := w.take(); len(changes) > 0; changes = w.take() {
+		var backoff time.Duration
+		var err error
+		for attempt := 0; attempt < closeFlushAttempts; attempt++ {
+			if attempt > 0 {
+				backoff = w.nextBackoff(backoff)
+				time.Sleep(backoff)
+				changes = w.take()
+			}
+			if err = w.flush(changes); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
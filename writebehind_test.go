@@ -0,0 +1,221 @@
+package memorystorecache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore records the batches written to it, failing the first fail calls
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]Change
+	fail    int
+	calls   int
+}
+
+func (s *fakeStore) WriteBatch(changes []Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls <= s.fail {
+		return errors.New("store unavailable")
+	}
+	s.batches = append(s.batches, changes)
+	return nil
+}
+
+// written returns every change written, formatted as key=value or key deleted
+func (s *fakeStore) written() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, b := range s.batches {
+		for _, ch := range b {
+			switch {
+			case ch.Deleted:
+				out = append(out, string(ch.Key)+" deleted")
+			default:
+				out = append(out, fmt.Sprintf("%s=%s", ch.Key, ch.Value))
+			}
+		}
+	}
+	return out
+}
+
+func TestWriteBehind(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	store := &fakeStore{}
+	c, err := NewCache(time.Minute, 0, WithClock(clk), WithWriteBehind(store, time.Second))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// repeated changes to a key are coalesced, and nothing is written before the interval
+	assert.Nil(t, conn.Write([]byte("a"), []byte("1")))
+	assert.Nil(t, conn.Write([]byte("b"), []byte("1")))
+	assert.Nil(t, conn.Write([]byte("a"), []byte("2")))
+	assert.Nil(t, conn.Delete([]byte("b")))
+	assert.Equal(t, uint64(2), conn.TypedStats().WriteBehindQueued)
+	assert.Empty(t, store.written())
+
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return len(store.written()) == 2 })
+	assert.Equal(t, []string{"a=2", "b deleted"}, store.written())
+
+	// expiry changes are written behind too
+	assert.Nil(t, conn.TouchTTL([]byte("a"), time.Hour))
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return len(store.written()) == 3 })
+	store.mu.Lock()
+	ch := store.batches[1][0]
+	store.mu.Unlock()
+	assert.True(t, ch.ExpiresAt.Equal(clk.Now().Add(time.Hour-time.Second)))
+
+	// invalidating a key only drops it from the cache
+	assert.Nil(t, conn.WriteWithTags([]byte("c"), []byte("1"), "t"))
+	_, err = conn.InvalidateTag("t")
+	assert.Nil(t, err)
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return len(store.written()) == 4 })
+	assert.Equal(t, "c=1", store.written()[3])
+
+	st := conn.TypedStats()
+	assert.Equal(t, uint64(0), st.WriteBehindQueued)
+	assert.Equal(t, uint64(4), st.WriteBehindFlushed)
+}
+
+func TestWriteBehindSkipsFills(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	store := &fakeStore{}
+	c, err := NewCache(time.Minute, 0, WithClock(clk), WithWriteBehind(store, time.Second))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// values loaded or restored from elsewhere are not written back
+	_, err = conn.GetOrLoad([]byte("loaded"), time.Minute, func() ([]byte, error) { return []byte("1"), nil })
+	assert.Nil(t, err)
+	other, err := NewCache(time.Minute, 0)
+	assert.Nil(t, err)
+	src, err := other.Open("")
+	assert.Nil(t, err)
+	defer src.Close()
+	assert.Nil(t, src.Write([]byte("restored"), []byte("1")))
+	var buf bytes.Buffer
+	assert.Nil(t, src.Snapshot(&buf))
+	assert.Nil(t, conn.Restore(&buf))
+	assert.Equal(t, uint64(0), conn.TypedStats().WriteBehindQueued)
+
+	// but client changes to them are
+	_, err = conn.Incr([]byte("loaded"), 1, KeepTTL)
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Touch([]byte("restored")))
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return len(store.written()) == 2 })
+	assert.Equal(t, []string{"loaded=2", "restored=1"}, store.written())
+}
+
+func TestWriteBehindBatch(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	store := &fakeStore{}
+	c, err := NewCache(time.Minute, 0, WithClock(clk), WithWriteBehind(store, time.Hour), WithWriteBehindBatch(2))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// a full batch starts a flush without waiting for the interval
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte("1")))
+	}
+	waitFor(t, func() bool { return len(store.written()) >= 4 })
+	store.mu.Lock()
+	for _, b := range store.batches {
+		assert.True(t, len(b) <= 2)
+	}
+	store.mu.Unlock()
+}
+
+func TestWriteBehindRetry(t *testing.T) {
+	store := &fakeStore{fail: 3}
+	c, err := NewCache(time.Minute, 0,
+		WithWriteBehind(store, 10*time.Millisecond),
+		WithWriteBehindBackoff(time.Millisecond, 4*time.Millisecond))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.Write([]byte("a"), []byte("1")))
+	waitFor(t, func() bool { return len(store.written()) == 1 })
+	st := conn.TypedStats()
+	assert.Equal(t, uint64(3), st.WriteBehindFailures)
+	assert.Equal(t, uint64(1), st.WriteBehindFlushed)
+}
+
+func TestWriteBehindRequeue(t *testing.T) {
+	w := &writeBehind{batch: 10, dirty: map[string]Change{}, kick: make(chan struct{}, 1)}
+	w.queue(Change{Key: []byte("a"), Value: []byte("1")})
+	w.queue(Change{Key: []byte("b"), Value: []byte("1")})
+	changes := w.take()
+	assert.Len(t, changes, 2)
+
+	// a key changed while its batch was failing keeps the newer change
+	w.queue(Change{Key: []byte("b"), Value: []byte("2")})
+	w.queue(Change{Key: []byte("c"), Value: []byte("1")})
+	w.requeue(changes)
+	var got []string
+	for _, ch := range w.take() {
+		got = append(got, fmt.Sprintf("%s=%s", ch.Key, ch.Value))
+	}
+	assert.Equal(t, []string{"a=1", "b=2", "c=1"}, got)
+}
+
+func TestWriteBehindClose(t *testing.T) {
+	store := &fakeStore{fail: 1}
+	c, err := NewCache(time.Minute, 0,
+		WithWriteBehind(store, time.Hour),
+		WithWriteBehindBackoff(time.Millisecond, time.Millisecond))
+	assert.Nil(t, err)
+	conn, err := c.Open("")
+	assert.Nil(t, err)
+
+	// Close flushes what is queued, retrying a failed batch
+	for i := 0; i < 250; i++ {
+		assert.Nil(t, conn.Write([]byte(fmt.Sprintf("key-%d", i)), []byte("1")))
+	}
+	assert.Nil(t, conn.Close())
+	assert.Len(t, store.written(), 250)
+
+	// and gives up on a store that keeps failing
+	store = &fakeStore{fail: 1000}
+	c, err = NewCache(time.Minute, 0,
+		WithWriteBehind(store, time.Hour),
+		WithWriteBehindBackoff(time.Millisecond, time.Millisecond))
+	assert.Nil(t, err)
+	conn, err = c.Open("")
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Write([]byte("a"), []byte("1")))
+	assert.EqualError(t, conn.Close(), "store unavailable")
+	assert.Equal(t, closeFlushAttempts, store.calls)
+	assert.Equal(t, uint64(1), conn.TypedStats().WriteBehindQueued)
+}
+
+func TestWriteBehindValidation(t *testing.T) {
+	_, err := NewCache(time.Minute, 0, WithWriteBehind(nil, time.Second))
+	assert.NotNil(t, err)
+	_, err = NewCache(time.Minute, 0, WithWriteBehind(&fakeStore{}, 0))
+	assert.NotNil(t, err)
+	_, err = NewCache(time.Minute, 0, WithWriteBehindBatch(0))
+	assert.NotNil(t, err)
+	_, err = NewCache(time.Minute, 0, WithWriteBehindBackoff(time.Second, time.Millisecond))
+	assert.NotNil(t, err)
+}